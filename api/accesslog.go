@@ -0,0 +1,95 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	mathrand "math/rand"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// requestIDHeader carries a request's ID; one is made up if the client
+// (or a proxy in front) hasn't sent one
+const requestIDHeader = "X-Request-ID"
+
+// AccessLogOptions controls what AccessLog logs
+type AccessLogOptions struct {
+	// SampleRate is the fraction of requests logged, from 0 to 1. Server
+	// errors (5xx) are always logged, whatever the rate.
+	SampleRate float64
+	// RedactQuery leaves out what was searched for, logging only its length
+	RedactQuery bool
+}
+
+// AccessLog logs a structured entry for each request to next, once it has
+// been answered. Every request is given an ID, which is sent back in the
+// X-Request-ID header so that it can be matched up with the log.
+func AccessLog(next http.Handler, logger log.FieldLogger, opts AccessLogOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, info := withRequestInfo(r)
+		info.id = r.Header.Get(requestIDHeader)
+		if info.id == "" {
+			info.id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, info.id)
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		status := rec.status()
+		if status < 500 && !sampled(opts.SampleRate) {
+			return
+		}
+
+		params := r.URL.Query()
+		fields := log.Fields{
+			"request_id": info.id,
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"status":     status,
+			"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+			// where people are is more sensitive than what they look for,
+			// so only ever say whether a location was given
+			"has_location": params.Get("latitude") != "" || params.Get("longitude") != "",
+		}
+		if q, ok := params["q"]; ok {
+			if opts.RedactQuery {
+				fields["query_length"] = len([]rune(q[0]))
+			} else {
+				fields["query"] = q[0]
+			}
+		}
+		if info.results >= 0 {
+			fields["results"] = info.results
+		}
+
+		entry := logger.WithFields(fields)
+		if status >= 500 {
+			entry.Error("request failed")
+		} else {
+			entry.Info("request")
+		}
+	})
+}
+
+// sampled is whether a request should be logged, given the sample rate
+func sampled(rate float64) bool {
+	if rate >= 1 || math.IsNaN(rate) {
+		return true
+	}
+	return mathrand.Float64() < rate
+}
+
+// newRequestID makes up a random ID for a request
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// not worth failing a request over; the time will do at a push
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
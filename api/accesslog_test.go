@@ -0,0 +1,173 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/oskanberg/citysearch/api"
+	"github.com/oskanberg/citysearch/cities"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestAccessLog(t *testing.T) {
+	type test struct {
+		name     string
+		url      string
+		header   string
+		opts     api.AccessLogOptions
+		searcher *mockSearcher
+		expected log.Fields
+		level    log.Level
+	}
+
+	// a new one each time, since the searcher records its calls
+	twoCities := func() *mockSearcher {
+		return &mockSearcher{cities: []cities.CityWithScore{{City: cities.City{Name: "Woking"}}, {City: cities.City{Name: "Wokingham"}}}}
+	}
+
+	cases := []test{
+		{
+			name:     "logs the request",
+			url:      "/suggestions?q=wok&latitude=51.3&longitude=-0.5",
+			header:   "abc123",
+			opts:     api.AccessLogOptions{SampleRate: 1},
+			searcher: twoCities(),
+			expected: log.Fields{
+				"request_id":   "abc123",
+				"method":       "GET",
+				"path":         "/suggestions",
+				"status":       200,
+				"has_location": true,
+				"query":        "wok",
+				"results":      2,
+			},
+			level: log.InfoLevel,
+		},
+		{
+			name:     "redacts the query",
+			url:      "/suggestions?q=wokingham",
+			header:   "abc123",
+			opts:     api.AccessLogOptions{SampleRate: 1, RedactQuery: true},
+			searcher: twoCities(),
+			expected: log.Fields{
+				"request_id":   "abc123",
+				"method":       "GET",
+				"path":         "/suggestions",
+				"status":       200,
+				"has_location": false,
+				"query_length": 9,
+				"results":      2,
+			},
+			level: log.InfoLevel,
+		},
+		{
+			name:     "no results for a bad request",
+			url:      "/suggestions?q=wok&limit=0",
+			header:   "abc123",
+			opts:     api.AccessLogOptions{SampleRate: 1},
+			searcher: twoCities(),
+			expected: log.Fields{
+				"request_id":   "abc123",
+				"method":       "GET",
+				"path":         "/suggestions",
+				"status":       400,
+				"has_location": false,
+				"query":        "wok",
+			},
+			level: log.InfoLevel,
+		},
+		{
+			name:     "sampled out",
+			url:      "/suggestions?q=wok",
+			opts:     api.AccessLogOptions{SampleRate: 0},
+			searcher: twoCities(),
+		},
+		{
+			name:     "server errors are always logged",
+			url:      "/suggestions?q=wok",
+			header:   "abc123",
+			opts:     api.AccessLogOptions{SampleRate: 0},
+			searcher: &mockSearcher{err: http.ErrAbortHandler},
+			expected: log.Fields{
+				"request_id":   "abc123",
+				"method":       "GET",
+				"path":         "/suggestions",
+				"status":       500,
+				"has_location": false,
+				"query":        "wok",
+			},
+			level: log.ErrorLevel,
+		},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			logger, hook := logtest.NewNullLogger()
+			handle := api.AccessLog(api.NewCitySearchHandler(tc.searcher), logger, tc.opts)
+
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if tc.header != "" {
+				req.Header.Set("X-Request-ID", tc.header)
+			}
+			rec := httptest.NewRecorder()
+			handle.ServeHTTP(rec, req)
+
+			if tc.header != "" && rec.Header().Get("X-Request-ID") != tc.header {
+				t.Fatalf("expected request ID %s to be sent back, got %q", tc.header, rec.Header().Get("X-Request-ID"))
+			}
+
+			entries := hook.AllEntries()
+			if tc.expected == nil {
+				if len(entries) != 0 {
+					t.Fatalf("expected nothing logged, got %v", entries)
+				}
+				return
+			}
+			if len(entries) != 1 {
+				t.Fatalf("expected one entry logged, got %d", len(entries))
+			}
+
+			e := entries[0]
+			if e.Level != tc.level {
+				t.Fatalf("expected level %s, got %s", tc.level, e.Level)
+			}
+			if _, ok := e.Data["latency_ms"].(float64); !ok {
+				t.Fatalf("expected a latency, got %v", e.Data["latency_ms"])
+			}
+			delete(e.Data, "latency_ms")
+			if len(e.Data) != len(tc.expected) {
+				t.Fatalf("expected fields %v, got %v", tc.expected, e.Data)
+			}
+			for k, v := range tc.expected {
+				if e.Data[k] != v {
+					t.Fatalf("expected %s to be %v, got %v", k, v, e.Data[k])
+				}
+			}
+		})
+	}
+}
+
+func TestAccessLogMakesUpRequestIDs(t *testing.T) {
+	t.Parallel()
+	logger, hook := logtest.NewNullLogger()
+	handle := api.AccessLog(api.NewCitySearchHandler(&mockSearcher{}), logger, api.AccessLogOptions{SampleRate: 1})
+
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		rec := httptest.NewRecorder()
+		handle.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/suggestions?q=wok", nil))
+
+		id := rec.Header().Get("X-Request-ID")
+		if id == "" || seen[id] {
+			t.Fatalf("expected a new request ID, got %q", id)
+		}
+		seen[id] = true
+		if logged := hook.LastEntry().Data["request_id"]; logged != id {
+			t.Fatalf("expected request ID %s to be logged, got %v", id, logged)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/oskanberg/citysearch/cities"
+)
+
+type CityFinder interface {
+	City(id string) (cities.City, error)
+}
+
+// cityDetails is everything there is to know about a city
+type cityDetails struct {
+	GeoNameID      string   `json:"geonameid"`
+	Name           string   `json:"name"`
+	ASCIIName      string   `json:"ascii_name"`
+	AlternateNames []string `json:"alternate_names"`
+	Lat            float64  `json:"latitude"`
+	Lng            float64  `json:"longitude"`
+	FeatureClass   string   `json:"feature_class"`
+	FeatureCode    string   `json:"feature_code"`
+	CountryCode    string   `json:"country_code"`
+	CC2            string   `json:"cc2"`
+	Admin1Code     string   `json:"admin1_code"`
+	Admin2Code     string   `json:"admin2_code"`
+	Admin3Code     string   `json:"admin3_code"`
+	Admin4Code     string   `json:"admin4_code"`
+	Population     int64    `json:"population"`
+	Elevation      int      `json:"elevation"`
+	DEM            int      `json:"dem"`
+	Timezone       string   `json:"timezone"`
+	// ModificationDate is as it is in the GeoNames dump
+	ModificationDate string `json:"modification_date"`
+}
+
+// NewCityHandler serves a single city, looked up by the GeoNames ID in the
+// path parameter id, such as one from a suggestion a client kept
+func NewCityHandler(finder CityFinder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r, http.MethodGet)
+			return
+		}
+
+		id := PathParam(r, "id")
+		c, err := finder.City(id)
+		if errors.Is(err, cities.ErrCityNotFound) {
+			writeError(w, r, http.StatusNotFound, codeNotFound, "id", fmt.Sprintf("no city has GeoNames ID %q", id))
+			return
+		}
+		if err != nil {
+			searchError(w, r, err)
+			return
+		}
+
+		alternates := c.AlternateNames
+		if alternates == nil {
+			alternates = []string{}
+		}
+		setResults(r.Context(), 1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cityDetails{
+			GeoNameID:        c.GeoNameID,
+			Name:             c.Name,
+			ASCIIName:        c.ASCIIName,
+			AlternateNames:   alternates,
+			Lat:              c.Lat,
+			Lng:              c.Lng,
+			FeatureClass:     c.FeatureClass,
+			FeatureCode:      c.FeatureCode,
+			CountryCode:      c.CountryCode,
+			CC2:              c.CC2,
+			Admin1Code:       c.Admin1Code,
+			Admin2Code:       c.Admin2Code,
+			Admin3Code:       c.Admin3Code,
+			Admin4Code:       c.Admin4Code,
+			Population:       c.Population,
+			Elevation:        c.Elevation,
+			DEM:              c.DEM,
+			Timezone:         c.Timezone,
+			ModificationDate: c.ModificationDate,
+		})
+	}
+}
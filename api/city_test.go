@@ -0,0 +1,111 @@
+package api_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/oskanberg/citysearch/api"
+	"github.com/oskanberg/citysearch/cities"
+)
+
+type mockFinder struct {
+	cities map[string]cities.City
+	err    error
+}
+
+func (f mockFinder) City(id string) (cities.City, error) {
+	if f.err != nil {
+		return cities.City{}, f.err
+	}
+	c, ok := f.cities[id]
+	if !ok {
+		return cities.City{}, cities.ErrCityNotFound
+	}
+	return c, nil
+}
+
+func TestCity(t *testing.T) {
+	type test struct {
+		name           string
+		url            string
+		finder         mockFinder
+		expectedStatus int
+		expectedBody   string
+	}
+
+	woking := cities.City{
+		GeoNameID:        "2633709",
+		Name:             "Woking",
+		ASCIIName:        "Woking",
+		AlternateNames:   cities.Names{"Uoking", "Vokingas"},
+		Lat:              51.31903,
+		Lng:              -0.55893,
+		FeatureClass:     "P",
+		FeatureCode:      "PPL",
+		CountryCode:      "GB",
+		Admin1Code:       "ENG",
+		Admin2Code:       "N7",
+		Admin3Code:       "43UM",
+		Population:       103932,
+		DEM:              39,
+		Timezone:         "Europe/London",
+		ModificationDate: "03/08/2010",
+	}
+	finder := mockFinder{cities: map[string]cities.City{
+		"2633709": woking,
+		"1":       {GeoNameID: "1", Name: "Nowhere"},
+	}}
+
+	cases := []test{
+		{
+			name:           "found",
+			url:            "/v1/cities/2633709",
+			finder:         finder,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"geonameid":"2633709","name":"Woking","ascii_name":"Woking","alternate_names":["Uoking","Vokingas"],"latitude":51.31903,"longitude":-0.55893,"feature_class":"P","feature_code":"PPL","country_code":"GB","cc2":"","admin1_code":"ENG","admin2_code":"N7","admin3_code":"43UM","admin4_code":"","population":103932,"elevation":0,"dem":39,"timezone":"Europe/London","modification_date":"03/08/2010"}` + "\n",
+		},
+		{
+			name:           "no alternate names is an empty list",
+			url:            "/v1/cities/1",
+			finder:         finder,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"geonameid":"1","name":"Nowhere","ascii_name":"","alternate_names":[],"latitude":0,"longitude":0,"feature_class":"","feature_code":"","country_code":"","cc2":"","admin1_code":"","admin2_code":"","admin3_code":"","admin4_code":"","population":0,"elevation":0,"dem":0,"timezone":"","modification_date":""}` + "\n",
+		},
+		{
+			name:           "not found",
+			url:            "/v1/cities/2",
+			finder:         finder,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error":{"code":"not_found","message":"no city has GeoNames ID \"2\"","param":"id"}}` + "\n",
+		},
+		{
+			name:           "not loaded yet",
+			url:            "/v1/cities/2633709",
+			finder:         mockFinder{err: cities.ErrNotReady},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{"error":{"code":"not_ready","message":"search unavailable: cities database not loaded yet"}}` + "\n",
+		},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			router := api.NewRouter()
+			router.Handle(http.MethodGet, "/v1/cities/{id}", api.NewCityHandler(tc.finder))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.url, nil))
+
+			if rec.Code != tc.expectedStatus {
+				t.Fatalf("expected status %d but got %d", tc.expectedStatus, rec.Code)
+			}
+			body, _ := ioutil.ReadAll(rec.Body)
+			if string(body) != tc.expectedBody {
+				t.Fatalf("expected body '%s', got '%s'", tc.expectedBody, string(body))
+			}
+		})
+	}
+}
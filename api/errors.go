@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/oskanberg/citysearch/cities"
+)
+
+// Error codes, for clients to act on without parsing messages
+const (
+	// codeMissingParameter is a required parameter that wasn't set
+	codeMissingParameter = "missing_parameter"
+	// codeInvalidParameter is a parameter that was set to something unusable
+	codeInvalidParameter = "invalid_parameter"
+	// codeNotFound is a path, or a city, that isn't there
+	codeNotFound = "not_found"
+	// codeMethodNotAllowed is a path that is there, but not for the method
+	codeMethodNotAllowed = "method_not_allowed"
+	// codeNotReady is the cities database not having been loaded yet
+	codeNotReady = "not_ready"
+	// codeTimeout is a search that ran out of time
+	codeTimeout = "timeout"
+	// codeCancelled is a search the client went away from
+	codeCancelled = "cancelled"
+	// codeInternal is anything else that went wrong
+	codeInternal = "internal"
+)
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Param is the parameter that was missing or invalid, if it was one
+	Param string `json:"param,omitempty"`
+	// RequestID matches the response up with the access log
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type errorDTO struct {
+	Error errorBody `json:"error"`
+}
+
+// paramError is a problem with the request parameter param
+type paramError struct {
+	param string
+	msg   string
+}
+
+func (e *paramError) Error() string {
+	return e.msg
+}
+
+// invalidParam is a paramError for param, with a message formatted from format and args
+func invalidParam(param, format string, args ...interface{}) error {
+	return &paramError{param, fmt.Sprintf(format, args...)}
+}
+
+// writeError responds to r with status and a JSON error body. param is the
+// request parameter at fault, if any.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, param, msg string) {
+	body := errorBody{Code: code, Message: msg, Param: param}
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		body.RequestID = info.id
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorDTO{body})
+}
+
+// badRequest responds to a request with a parameter that couldn't be used,
+// as described by err
+func badRequest(w http.ResponseWriter, r *http.Request, err error) {
+	var pe *paramError
+	if errors.As(err, &pe) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, pe.param, pe.msg)
+		return
+	}
+	writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "", err.Error())
+}
+
+// missingParam responds to a request without the required parameter param
+func missingParam(w http.ResponseWriter, r *http.Request, param, msg string) {
+	writeError(w, r, http.StatusBadRequest, codeMissingParameter, param, msg)
+}
+
+// methodNotAllowed responds to a request with a method other than the
+// allowed ones
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "", fmt.Sprintf("method %s not allowed", r.Method))
+}
+
+// searchError responds to a failed search: a 499 if the client cancelled
+// it, a 503 if it ran out of time or there's nothing loaded to search yet,
+// and a 500 for anything else
+func searchError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, cities.ErrNotReady):
+		writeError(w, r, http.StatusServiceUnavailable, codeNotReady, "", fmt.Sprintf("search unavailable: %s", err))
+	case errors.Is(err, context.Canceled):
+		writeError(w, r, statusClientClosedRequest, codeCancelled, "", fmt.Sprintf("search cancelled: %s", err))
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, r, http.StatusServiceUnavailable, codeTimeout, "", fmt.Sprintf("search timed out: %s", err))
+	default:
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "", fmt.Sprintf("search failed: %s", err))
+	}
+}
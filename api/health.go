@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// ReadinessSource is anything that can say whether it has cities to
+// search, such as a cities.Reloader
+type ReadinessSource interface {
+	StatsSource
+	Ready() bool
+}
+
+type healthDTO struct {
+	Status string `json:"status"`
+}
+
+type readinessDTO struct {
+	Status   string     `json:"status"`
+	Cities   int        `json:"cities,omitempty"`
+	Names    int        `json:"names,omitempty"`
+	Checksum string     `json:"checksum,omitempty"`
+	LoadedAt *time.Time `json:"loaded_at,omitempty"`
+}
+
+// NewLivenessHandler answers 200 for as long as the process can serve
+// requests at all. It deliberately knows nothing about the cities, so that
+// a slow or failed load doesn't get the process restarted.
+func NewLivenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, r, "GET, HEAD")
+			return
+		}
+		writeHealth(w, http.StatusOK, healthDTO{"ok"})
+	}
+}
+
+// NewReadinessHandler answers 200, describing the data being served, once
+// source has cities to search, and 503 until then
+func NewReadinessHandler(source ReadinessSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, r, "GET, HEAD")
+			return
+		}
+		if !source.Ready() {
+			writeHealth(w, http.StatusServiceUnavailable, readinessDTO{Status: "loading"})
+			return
+		}
+
+		stats := source.Stats()
+		writeHealth(w, http.StatusOK, readinessDTO{
+			Status:   "ready",
+			Cities:   stats.Cities,
+			Names:    stats.Names,
+			Checksum: stats.Checksum,
+			LoadedAt: &stats.BuiltAt,
+		})
+	}
+}
+
+func writeHealth(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	// probes want the current answer, not one a proxy remembered
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
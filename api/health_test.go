@@ -0,0 +1,99 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oskanberg/citysearch/api"
+	"github.com/oskanberg/citysearch/cities"
+)
+
+type stubReadiness struct {
+	stubStats
+	ready bool
+}
+
+func (s stubReadiness) Ready() bool {
+	return s.ready
+}
+
+func TestLiveness(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	api.NewLivenessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	loadedAt := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	type test struct {
+		name     string
+		source   stubReadiness
+		expected int
+		body     map[string]interface{}
+	}
+
+	cases := []test{
+		{
+			name:     "loading",
+			source:   stubReadiness{},
+			expected: http.StatusServiceUnavailable,
+			body:     map[string]interface{}{"status": "loading"},
+		},
+		{
+			name:     "ready",
+			source:   stubReadiness{stubStats{cities.Stats{Cities: 3, Names: 7, BuiltAt: loadedAt, Checksum: "abc123"}}, true},
+			expected: http.StatusOK,
+			body: map[string]interface{}{
+				"status":    "ready",
+				"cities":    float64(3),
+				"names":     float64(7),
+				"checksum":  "abc123",
+				"loaded_at": "2021-03-04T05:06:07Z",
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rec := httptest.NewRecorder()
+			api.NewReadinessHandler(tc.source).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tc.expected {
+				t.Fatalf("expected status %d, got %d", tc.expected, rec.Code)
+			}
+
+			var body map[string]interface{}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("expected JSON, got %s", err)
+			}
+			if len(body) != len(tc.body) {
+				t.Fatalf("expected %v, got %v", tc.body, body)
+			}
+			for k, v := range tc.body {
+				if body[k] != v {
+					t.Fatalf("expected %s to be %v, got %v", k, v, body[k])
+				}
+			}
+		})
+	}
+}
+
+func TestSearchNotReady(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	api.NewCitySearchHandler(&mockSearcher{err: cities.ErrNotReady}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/suggestions?q=wok", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
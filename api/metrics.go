@@ -0,0 +1,99 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/oskanberg/citysearch/cities"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StatsSource is anything that can describe the cities it searches, such
+// as a cities.CitySearcher or cities.Reloader
+type StatsSource interface {
+	Stats() cities.Stats
+}
+
+// Metrics are the Prometheus metrics for the service's requests and index
+type Metrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	results  *prometheus.HistogramVec
+	reloads  *prometheus.CounterVec
+}
+
+// NewMetrics creates the metrics, and registers them (along with gauges
+// describing the index in source) with reg
+func NewMetrics(reg prometheus.Registerer, source StatsSource) *Metrics {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "citysearch_http_requests_total",
+			Help: "Requests answered, by handler and status code.",
+		}, []string{"handler", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "citysearch_http_request_duration_seconds",
+			Help:    "How long requests took to answer, by handler.",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"handler"}),
+		results: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "citysearch_search_results",
+			Help:    "How many results successful requests returned, by handler.",
+			Buckets: []float64{0, 1, 2, 5, 10, 20, 50, 100},
+		}, []string{"handler"}),
+		reloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "citysearch_reloads_total",
+			Help: "Reloads of the cities database, by result (ok or error).",
+		}, []string{"result"}),
+	}
+
+	reg.MustRegister(
+		m.requests, m.duration, m.results, m.reloads,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "citysearch_index_cities",
+			Help: "Cities in the index being searched.",
+		}, func() float64 { return float64(source.Stats().Cities) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "citysearch_index_names",
+			Help: "Distinct names of cities in the index being searched.",
+		}, func() float64 { return float64(source.Stats().Names) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "citysearch_index_loaded_timestamp_seconds",
+			Help: "When the index being searched was (re)loaded, in seconds since the epoch.",
+		}, func() float64 {
+			builtAt := source.Stats().BuiltAt
+			if builtAt.IsZero() {
+				// nothing loaded yet
+				return 0
+			}
+			return float64(builtAt.UnixNano()) / 1e9
+		}),
+	)
+	return m
+}
+
+// Instrument records metrics for every request to next, under the name handler
+func (m *Metrics) Instrument(handler string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, info := withRequestInfo(r)
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		m.requests.WithLabelValues(handler, strconv.Itoa(rec.status())).Inc()
+		m.duration.WithLabelValues(handler).Observe(time.Since(start).Seconds())
+		if info.results >= 0 {
+			m.results.WithLabelValues(handler).Observe(float64(info.results))
+		}
+	})
+}
+
+// ObserveReload records the result of a reload of the cities database
+func (m *Metrics) ObserveReload(err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.reloads.WithLabelValues(result).Inc()
+}
@@ -0,0 +1,100 @@
+package api_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oskanberg/citysearch/api"
+	"github.com/oskanberg/citysearch/cities"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type stubStats struct {
+	stats cities.Stats
+}
+
+func (s stubStats) Stats() cities.Stats {
+	return s.stats
+}
+
+// gathered finds the metric called name with the given labels in reg
+func gathered(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) *dto.Metric {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %s", err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range f.GetMetric() {
+			if len(m.GetLabel()) != len(labels) {
+				continue
+			}
+			for _, l := range m.GetLabel() {
+				if labels[l.GetName()] != l.GetValue() {
+					continue metrics
+				}
+			}
+			return m
+		}
+	}
+	t.Fatalf("no metric %s%v", name, labels)
+	return nil
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	reg := prometheus.NewRegistry()
+	builtAt := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	metrics := api.NewMetrics(reg, stubStats{cities.Stats{Cities: 3, Names: 7, BuiltAt: builtAt}})
+
+	searcher := &mockSearcher{cities: []cities.CityWithScore{{City: cities.City{Name: "Woking"}}, {City: cities.City{Name: "Wokingham"}}}}
+	handle := metrics.Instrument("suggestions", api.NewCitySearchHandler(searcher))
+	for _, url := range []string{"/suggestions?q=wok", "/suggestions?q=wok&limit=5", "/suggestions?q="} {
+		handle.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+	}
+	metrics.ObserveReload(nil)
+	metrics.ObserveReload(errors.New("broken"))
+	metrics.ObserveReload(errors.New("broken"))
+
+	if v := gathered(t, reg, "citysearch_http_requests_total", map[string]string{"handler": "suggestions", "code": "200"}).GetCounter().GetValue(); v != 2 {
+		t.Fatalf("expected 2 OK requests, got %v", v)
+	}
+	if v := gathered(t, reg, "citysearch_http_requests_total", map[string]string{"handler": "suggestions", "code": "400"}).GetCounter().GetValue(); v != 1 {
+		t.Fatalf("expected 1 bad request, got %v", v)
+	}
+	if v := gathered(t, reg, "citysearch_http_request_duration_seconds", map[string]string{"handler": "suggestions"}).GetHistogram().GetSampleCount(); v != 3 {
+		t.Fatalf("expected 3 request durations, got %v", v)
+	}
+
+	// only the requests that returned results count towards them
+	results := gathered(t, reg, "citysearch_search_results", map[string]string{"handler": "suggestions"}).GetHistogram()
+	if results.GetSampleCount() != 2 || results.GetSampleSum() != 4 {
+		t.Fatalf("expected 2 requests with 4 results between them, got %v with %v", results.GetSampleCount(), results.GetSampleSum())
+	}
+
+	if v := gathered(t, reg, "citysearch_reloads_total", map[string]string{"result": "ok"}).GetCounter().GetValue(); v != 1 {
+		t.Fatalf("expected 1 good reload, got %v", v)
+	}
+	if v := gathered(t, reg, "citysearch_reloads_total", map[string]string{"result": "error"}).GetCounter().GetValue(); v != 2 {
+		t.Fatalf("expected 2 failed reloads, got %v", v)
+	}
+
+	if v := gathered(t, reg, "citysearch_index_cities", nil).GetGauge().GetValue(); v != 3 {
+		t.Fatalf("expected 3 cities, got %v", v)
+	}
+	if v := gathered(t, reg, "citysearch_index_names", nil).GetGauge().GetValue(); v != 7 {
+		t.Fatalf("expected 7 names, got %v", v)
+	}
+	if v := gathered(t, reg, "citysearch_index_loaded_timestamp_seconds", nil).GetGauge().GetValue(); v != float64(builtAt.Unix()) {
+		t.Fatalf("expected loaded at %v, got %v", builtAt.Unix(), v)
+	}
+}
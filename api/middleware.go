@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// WithTimeout gives every request to next at most d to be answered in.
+// Unlike http.TimeoutHandler it doesn't write a response of its own: the
+// deadline goes on the request's context, so searches stop when it passes
+// and the handler answers with a 503 as it would for any other deadline.
+func WithTimeout(next http.Handler, d time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestInfo is what a handler found out about a request that the
+// middleware around it wants to know, e.g. for metrics and logging
+type requestInfo struct {
+	// id identifies the request in logs; empty if it isn't being logged
+	id string
+	// results is how many results were returned, or -1 if none were
+	// (because the request failed, or isn't one that returns results)
+	results int
+}
+
+type requestInfoKey struct{}
+
+// withRequestInfo returns r with a requestInfo in its context for the
+// handler to fill in, or the one already there if another middleware
+// has added it
+func withRequestInfo(r *http.Request) (*http.Request, *requestInfo) {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return r, info
+	}
+	info := &requestInfo{results: -1}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)), info
+}
+
+// setResults records how many results a request returned, if anything
+// is around to hear it
+func setResults(ctx context.Context, n int) {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		info.results = n
+	}
+}
+
+// statusRecorder is a ResponseWriter that remembers the status it was given
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// status is the status sent, which is a 200 if the handler sent nothing
+func (s *statusRecorder) status() int {
+	if s.code == 0 {
+		return http.StatusOK
+	}
+	return s.code
+}
@@ -0,0 +1,69 @@
+package api_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oskanberg/citysearch/api"
+	"github.com/oskanberg/citysearch/cities"
+)
+
+// slowSearcher takes until its context is done to search
+type slowSearcher struct {
+	mockSearcher
+}
+
+func (s *slowSearcher) Search(ctx context.Context, query string, opts cities.SearchOptions) ([]cities.CityWithScore, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestWithTimeout(t *testing.T) {
+	t.Parallel()
+
+	handle := api.WithTimeout(api.NewCitySearchHandler(&slowSearcher{}), 10*time.Millisecond)
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/suggestions?q=foo", nil)
+	if err != nil {
+		t.Fatalf("failure making test request: '%s'", err)
+	}
+
+	start := time.Now()
+	handle.ServeHTTP(rec, req)
+	if took := time.Since(start); took > 5*time.Second {
+		t.Fatalf("expected the request to be cut short, but it took %s", took)
+	}
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d but got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	body, _ := ioutil.ReadAll(rec.Body)
+	if expected := `{"error":{"code":"timeout","message":"search timed out: context deadline exceeded"}}` + "\n"; string(body) != expected {
+		t.Fatalf("expected error '%s', got '%s'", expected, string(body))
+	}
+}
+
+func TestWithTimeoutKeepsEarlierDeadline(t *testing.T) {
+	t.Parallel()
+
+	var deadline time.Time
+	handle := api.WithTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ = r.Context().Deadline()
+	}), time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/suggestions?q=foo", nil)
+	if err != nil {
+		t.Fatalf("failure making test request: '%s'", err)
+	}
+	handle.ServeHTTP(httptest.NewRecorder(), req)
+
+	if expected, _ := ctx.Deadline(); !deadline.Equal(expected) {
+		t.Fatalf("expected deadline %s, got %s", expected, deadline)
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/oskanberg/citysearch/cities"
+)
+
+// defaultK is how many cities /nearest returns when k isn't set
+const defaultK = 1
+
+type CityLocator interface {
+	Nearest(ctx context.Context, lat, lng float64, k int) ([]cities.CityWithDistance, error)
+}
+
+type nearestResult struct {
+	// GeoNameID is for looking the city up again, at /v1/cities/{id}
+	GeoNameID   string  `json:"geonameid"`
+	Name        string  `json:"name"`
+	Lat         float64 `json:"latitude"`
+	Lng         float64 `json:"longitude"`
+	CountryCode string  `json:"country_code"`
+	Admin1Code  string  `json:"admin1_code"`
+	Admin2Code  string  `json:"admin2_code"`
+	Population  int64   `json:"population"`
+	Timezone    string  `json:"timezone"`
+
+	DistanceKm float64 `json:"distance_km"`
+}
+
+type nearestResultDTO struct {
+	Nearest []nearestResult `json:"nearest"`
+}
+
+// NewNearestHandler serves reverse geocoding: the k cities closest to a
+// latitude and longitude, closest first
+func NewNearestHandler(locator CityLocator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r, http.MethodGet)
+			return
+		}
+
+		params := r.URL.Query()
+		lat, lng, locSet, err := getLatLng(params)
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+		if !locSet {
+			missingParam(w, r, "latitude", "latitude and longitude must be set in URL")
+			return
+		}
+
+		k := defaultK
+		if kStr := params.Get("k"); kStr != "" {
+			k, err = strconv.Atoi(kStr)
+			if err != nil || k < 1 || k > maxLimit {
+				badRequest(w, r, invalidParam("k", "k must be a whole number from 1 to %d", maxLimit))
+				return
+			}
+		}
+
+		result, err := locator.Nearest(r.Context(), lat, lng, k)
+		if err != nil {
+			searchError(w, r, err)
+			return
+		}
+
+		nr := make([]nearestResult, len(result))
+		for i, v := range result {
+			nr[i] = nearestResult{
+				GeoNameID:   v.GeoNameID,
+				Name:        v.Name,
+				Lat:         v.Lat,
+				Lng:         v.Lng,
+				CountryCode: v.CountryCode,
+				Admin1Code:  v.Admin1Code,
+				Admin2Code:  v.Admin2Code,
+				Population:  v.Population,
+				Timezone:    v.Timezone,
+				DistanceKm:  v.DistanceKm,
+			}
+		}
+
+		setResults(r.Context(), len(nr))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(nearestResultDTO{nr})
+	}
+}
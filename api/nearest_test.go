@@ -0,0 +1,142 @@
+package api_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/oskanberg/citysearch/api"
+	"github.com/oskanberg/citysearch/cities"
+)
+
+type mockLocator struct {
+	// records of arg calls
+	lat, lng float64
+	k        int
+
+	// to return when invoked
+	cities []cities.CityWithDistance
+}
+
+func (l *mockLocator) Nearest(ctx context.Context, lat, lng float64, k int) ([]cities.CityWithDistance, error) {
+	l.lat, l.lng, l.k = lat, lng, k
+	return l.cities, nil
+}
+
+func TestNearest(t *testing.T) {
+	type test struct {
+		name           string
+		url            string
+		expectedStatus int
+		expectedBody   string
+		expectedK      int
+	}
+
+	wokingham := cities.CityWithDistance{
+		City: cities.City{
+			GeoNameID:   "2633708",
+			Name:        "Wokingham",
+			Lat:         51.4112,
+			Lng:         -0.83565,
+			CountryCode: "GB",
+			Admin1Code:  "ENG",
+			Admin2Code:  "K2",
+			Population:  41143,
+			Timezone:    "Europe/London",
+		},
+		DistanceKm: 1.5,
+	}
+
+	cases := []test{
+		{
+			name:           "no location",
+			url:            "/nearest?k=3",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":{"code":"missing_parameter","message":"latitude and longitude must be set in URL","param":"latitude"}}` + "\n",
+		},
+		{
+			name:           "lat not number",
+			url:            "/nearest?latitude=a&longitude=0",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":{"code":"invalid_parameter","message":"latitude was not a number","param":"latitude"}}` + "\n",
+		},
+		{
+			name:           "lat not a number at all",
+			url:            "/nearest?latitude=NaN&longitude=0",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":{"code":"invalid_parameter","message":"latitude must be from -90 to 90","param":"latitude"}}` + "\n",
+		},
+		{
+			name:           "lng out of range",
+			url:            "/nearest?latitude=51.4&longitude=360",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":{"code":"invalid_parameter","message":"longitude must be from -180 to 180","param":"longitude"}}` + "\n",
+		},
+		{
+			name:           "k too big",
+			url:            "/nearest?latitude=51.4&longitude=-0.8&k=101",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":{"code":"invalid_parameter","message":"k must be a whole number from 1 to 100","param":"k"}}` + "\n",
+		},
+		{
+			name:           "k not a number",
+			url:            "/nearest?latitude=51.4&longitude=-0.8&k=some",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":{"code":"invalid_parameter","message":"k must be a whole number from 1 to 100","param":"k"}}` + "\n",
+		},
+		{
+			name:           "default k",
+			url:            "/nearest?latitude=51.4&longitude=-0.8",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"nearest":[{"geonameid":"2633708","name":"Wokingham","latitude":51.4112,"longitude":-0.83565,"country_code":"GB","admin1_code":"ENG","admin2_code":"K2","population":41143,"timezone":"Europe/London","distance_km":1.5}]}` + "\n",
+			expectedK:      1,
+		},
+		{
+			name:           "k passed through",
+			url:            "/nearest?latitude=51.4&longitude=-0.8&k=5",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"nearest":[{"geonameid":"2633708","name":"Wokingham","latitude":51.4112,"longitude":-0.83565,"country_code":"GB","admin1_code":"ENG","admin2_code":"K2","population":41143,"timezone":"Europe/London","distance_km":1.5}]}` + "\n",
+			expectedK:      5,
+		},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			locator := &mockLocator{cities: []cities.CityWithDistance{wokingham}}
+			handle := api.NewNearestHandler(locator)
+			rec := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, tc.url, nil)
+			if err != nil {
+				t.Fatalf("failure making test request: '%s'", err)
+			}
+			handle(rec, req)
+
+			if rec.Code != tc.expectedStatus {
+				t.Fatalf("expected status %d but got %d", tc.expectedStatus, rec.Code)
+			}
+			body, _ := ioutil.ReadAll(rec.Body)
+			if string(body) != tc.expectedBody {
+				t.Fatalf("expected body '%s', got '%s'", tc.expectedBody, string(body))
+			}
+			if tc.expectedK != 0 && (locator.k != tc.expectedK || locator.lat != 51.4 || locator.lng != -0.8) {
+				t.Fatalf("expected to call locator with 51.4, -0.8 and k %d, got %v, %v and %d", tc.expectedK, locator.lat, locator.lng, locator.k)
+			}
+		})
+	}
+}
+
+func TestNearestMethodNotAllowed(t *testing.T) {
+	t.Parallel()
+	handle := api.NewNearestHandler(&mockLocator{})
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/nearest?latitude=0&longitude=0", strings.NewReader(""))
+	handle(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status %d but got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Router sends requests to handlers by method and path. A pattern is
+// matched against the path a segment at a time, and a segment in braces,
+// like the {id} in /v1/cities/{id}, matches any one segment, which the
+// handler can get with PathParam. Unlike http.ServeMux, there are no
+// subtree patterns: a pattern matches its path and nothing else.
+//
+// A path no route matches gets a 404, and one that is matched but not for
+// the request's method gets a 405 listing the methods that are; both JSON.
+type Router struct {
+	routes []route
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []string
+	handler  http.Handler
+}
+
+// routeMatch is the route a request was sent to, and the path parameters it matched
+type routeMatch struct {
+	pattern string
+	params  map[string]string
+}
+
+type routeMatchKey struct{}
+
+// NewRouter creates a Router with no routes
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle routes requests with method to a path matching pattern to h.
+// Routes are tried in the order they were added, so where two patterns
+// match the same path, the first added wins.
+func (rt *Router) Handle(method, pattern string, h http.Handler) {
+	rt.routes = append(rt.routes, route{method, pattern, splitPath(pattern), h})
+}
+
+// HandleFunc is Handle for a handler function
+func (rt *Router) HandleFunc(method, pattern string, h http.HandlerFunc) {
+	rt.Handle(method, pattern, h)
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := splitPath(r.URL.Path)
+
+	var allowed []string
+	for _, rte := range rt.routes {
+		params, ok := rte.match(path)
+		if !ok {
+			continue
+		}
+		if rte.method != r.Method {
+			allowed = append(allowed, rte.method)
+			continue
+		}
+		r = r.WithContext(context.WithValue(r.Context(), routeMatchKey{}, routeMatch{rte.pattern, params}))
+		rte.handler.ServeHTTP(w, r)
+		return
+	}
+
+	if len(allowed) > 0 {
+		sort.Strings(allowed)
+		methodNotAllowed(w, r, strings.Join(allowed, ", "))
+		return
+	}
+	writeError(w, r, http.StatusNotFound, codeNotFound, "", fmt.Sprintf("no such path %s", r.URL.Path))
+}
+
+// match is whether path fits the route's pattern, and if so what the
+// parameters in it were
+func (rte route) match(path []string) (map[string]string, bool) {
+	if len(path) != len(rte.segments) {
+		return nil, false
+	}
+	var params map[string]string
+	for i, seg := range rte.segments {
+		if name, ok := paramName(seg); ok {
+			if path[i] == "" {
+				return nil, false
+			}
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[name] = path[i]
+			continue
+		}
+		if seg != path[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// PathParam returns the segment of r's path that matched {name} in its
+// route's pattern, or "" if there was none
+func PathParam(r *http.Request, name string) string {
+	m, _ := r.Context().Value(routeMatchKey{}).(routeMatch)
+	return m.params[name]
+}
+
+// routePattern returns the pattern of the route r was sent to, such as
+// /v1/cities/{id}, or "" if it didn't come through a Router
+func routePattern(r *http.Request) string {
+	m, _ := r.Context().Value(routeMatchKey{}).(routeMatch)
+	return m.pattern
+}
+
+// Deprecated marks responses from next as coming from a path that is going
+// away, pointing to successor as the one to use instead
+func Deprecated(next http.Handler, successor string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+">; rel=\"successor-version\"")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// splitPath splits a path into its segments, leading slash aside, so
+// "/v1/cities/" is "v1", "cities" and ""
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+func paramName(seg string) (string, bool) {
+	if len(seg) > 2 && strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+		return seg[1 : len(seg)-1], true
+	}
+	return "", false
+}
@@ -0,0 +1,176 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/oskanberg/citysearch/api"
+)
+
+// named answers with its name and any path parameters, to tell which
+// route a request went to
+func named(name string, params ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{"route": name}
+		for _, p := range params {
+			body[p] = api.PathParam(r, p)
+		}
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	router := api.NewRouter()
+	router.Handle(http.MethodGet, "/v1/suggestions", named("suggestions"))
+	router.Handle(http.MethodGet, "/v1/cities/nearest", named("nearest"))
+	router.Handle(http.MethodGet, "/v1/cities/{id}", named("city", "id"))
+	router.Handle(http.MethodDelete, "/v1/cities/{id}", named("delete", "id"))
+	router.Handle(http.MethodGet, "/", named("root"))
+
+	type test struct {
+		name     string
+		method   string
+		url      string
+		expected int
+		body     map[string]string
+		code     string
+		allow    string
+	}
+
+	cases := []test{
+		{
+			name:     "exact",
+			method:   http.MethodGet,
+			url:      "/v1/suggestions?q=wok",
+			expected: http.StatusOK,
+			body:     map[string]string{"route": "suggestions"},
+		},
+		{
+			name:     "param",
+			method:   http.MethodGet,
+			url:      "/v1/cities/2633563",
+			expected: http.StatusOK,
+			body:     map[string]string{"route": "city", "id": "2633563"},
+		},
+		{
+			name:     "first added wins",
+			method:   http.MethodGet,
+			url:      "/v1/cities/nearest",
+			expected: http.StatusOK,
+			body:     map[string]string{"route": "nearest"},
+		},
+		{
+			name:     "by method",
+			method:   http.MethodDelete,
+			url:      "/v1/cities/2633563",
+			expected: http.StatusOK,
+			body:     map[string]string{"route": "delete", "id": "2633563"},
+		},
+		{
+			name:     "root",
+			method:   http.MethodGet,
+			url:      "/",
+			expected: http.StatusOK,
+			body:     map[string]string{"route": "root"},
+		},
+		{
+			name:     "unknown path",
+			method:   http.MethodGet,
+			url:      "/v2/suggestions",
+			expected: http.StatusNotFound,
+			code:     "not_found",
+		},
+		{
+			name:     "no subtrees",
+			method:   http.MethodGet,
+			url:      "/v1/suggestions/more",
+			expected: http.StatusNotFound,
+			code:     "not_found",
+		},
+		{
+			name:     "trailing slash",
+			method:   http.MethodGet,
+			url:      "/v1/suggestions/",
+			expected: http.StatusNotFound,
+			code:     "not_found",
+		},
+		{
+			name:     "empty param",
+			method:   http.MethodGet,
+			url:      "/v1/cities/",
+			expected: http.StatusNotFound,
+			code:     "not_found",
+		},
+		{
+			name:     "wrong method",
+			method:   http.MethodPost,
+			url:      "/v1/cities/2633563",
+			expected: http.StatusMethodNotAllowed,
+			code:     "method_not_allowed",
+			allow:    "DELETE, GET",
+		},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.url, nil))
+			if rec.Code != tc.expected {
+				t.Fatalf("expected status %d, got %d", tc.expected, rec.Code)
+			}
+			if allow := rec.Header().Get("Allow"); allow != tc.allow {
+				t.Fatalf("expected Allow %q, got %q", tc.allow, allow)
+			}
+
+			if tc.code != "" {
+				var body struct {
+					Error struct {
+						Code    string `json:"code"`
+						Message string `json:"message"`
+					} `json:"error"`
+				}
+				if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+					t.Fatalf("expected JSON, got %s", err)
+				}
+				if body.Error.Code != tc.code || body.Error.Message == "" {
+					t.Fatalf("expected a %s error, got %+v", tc.code, body.Error)
+				}
+				return
+			}
+
+			var body map[string]string
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("expected JSON, got %s", err)
+			}
+			if len(body) != len(tc.body) {
+				t.Fatalf("expected %v, got %v", tc.body, body)
+			}
+			for k, v := range tc.body {
+				if body[k] != v {
+					t.Fatalf("expected %s to be %q, got %q", k, v, body[k])
+				}
+			}
+		})
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	api.Deprecated(named("suggestions"), "/v1/suggestions").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/suggestions?q=wok", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if d := rec.Header().Get("Deprecation"); d != "true" {
+		t.Fatalf("expected a Deprecation header, got %q", d)
+	}
+	if l := rec.Header().Get("Link"); l != `</v1/suggestions>; rel="successor-version"` {
+		t.Fatalf("expected a link to the successor, got %q", l)
+	}
+}
@@ -3,28 +3,53 @@ package api
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"net/url"
-	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/oskanberg/citysearch/cities"
 )
 
+// statusClientClosedRequest is the (non-standard, from nginx) status for
+// a request whose client went away before it could be answered
+const statusClientClosedRequest = 499
+
+const (
+	// defaultLimit is how many suggestions are returned when limit isn't set
+	defaultLimit = 10
+	// maxLimit and maxOffset stop a single request asking for everything.
+	// Searches find no more than cities.MaxResults, so no page goes past them.
+	maxLimit  = 100
+	maxOffset = cities.MaxResults - maxLimit
+)
+
 type CitySearcher interface {
-	Search(ctx context.Context, query string) ([]cities.CityWithScore, error)
-	SearchWithLocation(ctx context.Context, query string, lat, lng float64) ([]cities.CityWithScore, error)
+	Search(ctx context.Context, query string, opts cities.SearchOptions) ([]cities.CityWithScore, error)
+	SearchWithLocation(ctx context.Context, query string, lat, lng float64, opts cities.SearchOptions) ([]cities.CityWithScore, error)
+	SearchWithin(ctx context.Context, query string, area cities.Area, opts cities.SearchOptions) ([]cities.CityWithScore, error)
+	SearchWithLocationWithin(ctx context.Context, query string, lat, lng float64, area cities.Area, opts cities.SearchOptions) ([]cities.CityWithScore, error)
 }
 
 type cityResult struct {
-	Name string `json:"name"`
+	// GeoNameID is for looking the city up again, at /v1/cities/{id}
+	GeoNameID string `json:"geonameid"`
+	Name      string `json:"name"`
+	// MatchedName is the variant of the name that matched, e.g. "Londres" for London
+	MatchedName string `json:"matched_name"`
 
 	// I noticed in the sample response that these are strings.
 	// I think float64s make more sense - hope that's fair
 	Lat float64 `json:"latitude"`
 	Lng float64 `json:"longitude"`
 
+	// enough to tell apart places with the same name
+	CountryCode string `json:"country_code"`
+	Admin1Code  string `json:"admin1_code"`
+	Admin2Code  string `json:"admin2_code"`
+	Population  int64  `json:"population"`
+	Timezone    string `json:"timezone"`
+
 	Score float64 `json:"score"`
 }
 
@@ -35,33 +60,70 @@ type searchResultSDTO struct {
 func NewCitySearchHandler(searcher CitySearcher) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			methodNotAllowed(w, r, http.MethodGet)
 			return
 		}
 
 		params := r.URL.Query()
 		query := params.Get("q")
 		if query == "" {
-			http.Error(w, "q (query string) must be set in URL", http.StatusBadRequest)
+			missingParam(w, r, "q", "q (query string) must be set in URL")
 			return
 		}
 
 		lat, lng, locSet, err := getLatLng(params)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("latitude/longitude error: %s", err.Error()), http.StatusBadRequest)
+			badRequest(w, r, err)
+			return
+		}
+
+		opts, err := getPage(params)
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		opts.Mode, err = getMode(params)
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		opts.Countries, opts.Admin1, err = getRegions(params)
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		if expr := params.Get("filter"); expr != "" {
+			opts.Filter, err = cities.ParseFilter(expr)
+			if err != nil {
+				badRequest(w, r, invalidParam("filter", "%s", err))
+				return
+			}
+		}
+
+		area, err := getArea(params, lat, lng, locSet)
+		if err != nil {
+			badRequest(w, r, err)
 			return
 		}
 
 		ctx := r.Context()
 		var result []cities.CityWithScore
-		if locSet {
-			result, err = searcher.SearchWithLocation(ctx, query, lat, lng)
-		} else {
-			result, err = searcher.Search(ctx, query)
+		switch {
+		case locSet && area != nil:
+			result, err = searcher.SearchWithLocationWithin(ctx, query, lat, lng, area, opts)
+		case locSet:
+			result, err = searcher.SearchWithLocation(ctx, query, lat, lng, opts)
+		case area != nil:
+			result, err = searcher.SearchWithin(ctx, query, area, opts)
+		default:
+			result, err = searcher.Search(ctx, query, opts)
 		}
 
 		if err != nil {
-			http.Error(w, fmt.Sprintf("search failed: %s", err), http.StatusInternalServerError)
+			searchError(w, r, err)
 			return
 		}
 
@@ -69,19 +131,25 @@ func NewCitySearchHandler(searcher CitySearcher) http.HandlerFunc {
 		cr := make([]cityResult, len(result))
 		for i, v := range result {
 			cr[i] = cityResult{
-				Name:  v.Name,
-				Lat:   v.Lat,
-				Lng:   v.Lng,
-				Score: v.Score,
+				GeoNameID:   v.GeoNameID,
+				Name:        v.Name,
+				MatchedName: v.MatchedName,
+				Lat:         v.Lat,
+				Lng:         v.Lng,
+				CountryCode: v.CountryCode,
+				Admin1Code:  v.Admin1Code,
+				Admin2Code:  v.Admin2Code,
+				Population:  v.Population,
+				Timezone:    v.Timezone,
+				Score:       v.Score,
 			}
 		}
 
-		// make sure we are returning results highest score first
-		sort.Slice(cr, func(i, j int) bool {
-			return cr[i].Score > cr[j].Score
-		})
-
+		setResults(r.Context(), len(cr))
+		w.Header().Set("Content-Type", "application/json")
+		_, span := startSpan(r.Context(), "encode")
 		json.NewEncoder(w).Encode(searchResultSDTO{cr})
+		span.End()
 	}
 }
 
@@ -96,18 +164,146 @@ func getLatLng(params url.Values) (float64, float64, bool, error) {
 
 	// at this point, one must be set; but check that they both are
 	if latStr == "" || lngStr == "" {
-		return 0, 0, false, fmt.Errorf("only one angle was provided")
+		missing := "latitude"
+		if latStr != "" {
+			missing = "longitude"
+		}
+		return 0, 0, false, invalidParam(missing, "only one angle was provided")
 	}
 
 	lat, err := strconv.ParseFloat(latStr, 64)
 	if err != nil {
-		return 0, 0, false, fmt.Errorf("latitude was not a number")
+		return 0, 0, false, invalidParam("latitude", "latitude was not a number")
+	}
+	// written this way round so that NaN, which fails every comparison, is out of range too
+	if !(lat >= -90 && lat <= 90) {
+		return 0, 0, false, invalidParam("latitude", "latitude must be from -90 to 90")
 	}
 
 	lng, err := strconv.ParseFloat(lngStr, 64)
 	if err != nil {
-		return 0, 0, false, fmt.Errorf("longitude was not a number")
+		return 0, 0, false, invalidParam("longitude", "longitude was not a number")
+	}
+	if !(lng >= -180 && lng <= 180) {
+		return 0, 0, false, invalidParam("longitude", "longitude must be from -180 to 180")
 	}
 
 	return lat, lng, true, nil
 }
+
+// getArea reads the area results are limited to, if any: either a bbox of
+// min longitude, min latitude, max longitude, max latitude (the GeoJSON
+// order), or radius_km around the caller's location
+func getArea(params url.Values, lat, lng float64, locSet bool) (cities.Area, error) {
+	bboxStr := params.Get("bbox")
+	radiusStr := params.Get("radius_km")
+
+	switch {
+	case bboxStr != "" && radiusStr != "":
+		return nil, invalidParam("radius_km", "only one of bbox and radius_km can be set")
+
+	case bboxStr != "":
+		parts := strings.Split(bboxStr, ",")
+		if len(parts) != 4 {
+			return nil, invalidParam("bbox", "bbox must be four numbers: min longitude, min latitude, max longitude, max latitude")
+		}
+		var v [4]float64
+		for i, p := range parts {
+			f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+			if err != nil {
+				return nil, invalidParam("bbox", "bbox must be four numbers: min longitude, min latitude, max longitude, max latitude")
+			}
+			v[i] = f
+		}
+		box := cities.BoundingBox{MinLng: v[0], MinLat: v[1], MaxLng: v[2], MaxLat: v[3]}
+		// as with latitude and longitude, NaN fails these
+		if !(box.MinLat >= -90 && box.MaxLat <= 90 && box.MinLat <= box.MaxLat) {
+			return nil, invalidParam("bbox", "bbox latitudes must be from -90 to 90, min first")
+		}
+		// min longitude can be more than max, for a box over the antimeridian
+		if !(box.MinLng >= -180 && box.MinLng <= 180 && box.MaxLng >= -180 && box.MaxLng <= 180) {
+			return nil, invalidParam("bbox", "bbox longitudes must be from -180 to 180")
+		}
+		return box, nil
+
+	case radiusStr != "":
+		if !locSet {
+			return nil, invalidParam("radius_km", "radius_km needs latitude and longitude to be set")
+		}
+		radius, err := strconv.ParseFloat(radiusStr, 64)
+		if err != nil || !(radius > 0) {
+			return nil, invalidParam("radius_km", "radius_km must be a positive number")
+		}
+		return cities.Circle{Lat: lat, Lng: lng, RadiusKm: radius}, nil
+	}
+
+	return nil, nil
+}
+
+func getPage(params url.Values) (cities.SearchOptions, error) {
+	opts := cities.SearchOptions{Limit: defaultLimit}
+
+	if limitStr := params.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return opts, invalidParam("limit", "limit must be a whole number from 1 to %d", maxLimit)
+		}
+		opts.Limit = limit
+	}
+
+	if offsetStr := params.Get("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 || offset > maxOffset {
+			return opts, invalidParam("offset", "offset must be a whole number from 0 to %d", maxOffset)
+		}
+		opts.Offset = offset
+	}
+
+	return opts, nil
+}
+
+func getMode(params url.Values) (cities.Mode, error) {
+	switch params.Get("mode") {
+	case "", "fuzzy":
+		return cities.ModeFuzzy, nil
+	case "prefix":
+		return cities.ModePrefix, nil
+	case "typo":
+		return cities.ModeTypo, nil
+	default:
+		return cities.ModeFuzzy, invalidParam("mode", "mode must be fuzzy, prefix or typo")
+	}
+}
+
+// getRegions reads the comma separated country and admin1 codes to limit
+// results to; both are optional
+func getRegions(params url.Values) ([]string, []string, error) {
+	countries := splitList(params.Get("country"))
+	for i, c := range countries {
+		if len(c) != 2 {
+			return nil, nil, invalidParam("country", "country must be a comma separated list of two letter country codes")
+		}
+		countries[i] = strings.ToUpper(c)
+	}
+
+	admin1 := splitList(params.Get("admin1"))
+	for _, a := range admin1 {
+		if a == "" {
+			return nil, nil, invalidParam("admin1", "admin1 must be a comma separated list of admin1 codes")
+		}
+	}
+
+	return countries, admin1, nil
+}
+
+// splitList splits a comma separated parameter, with nil for an empty one
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	for i, p := range parts {
+		parts[i] = strings.TrimSpace(p)
+	}
+	return parts
+}
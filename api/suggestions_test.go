@@ -2,34 +2,54 @@ package api_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/oskanberg/citysearch/api"
 	"github.com/oskanberg/citysearch/cities"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 )
 
 type mockSearcher struct {
 	// records of arg calls
 	query    string
 	lat, lng float64
+	opts     cities.SearchOptions
+	area     cities.Area
 
 	// to return when invoked
 	cities []cities.CityWithScore
+	err    error
 }
 
-func (cs *mockSearcher) Search(ctx context.Context, query string) ([]cities.CityWithScore, error) {
+func (cs *mockSearcher) Search(ctx context.Context, query string, opts cities.SearchOptions) ([]cities.CityWithScore, error) {
 	cs.query = query
-	return cs.cities, nil
+	cs.opts = opts
+	return cs.cities, cs.err
 }
 
-func (cs *mockSearcher) SearchWithLocation(ctx context.Context, query string, lat, lng float64) ([]cities.CityWithScore, error) {
+func (cs *mockSearcher) SearchWithLocation(ctx context.Context, query string, lat, lng float64, opts cities.SearchOptions) ([]cities.CityWithScore, error) {
 	cs.query = query
+	cs.opts = opts
 	cs.lat = lat
 	cs.lng = lng
-	return cs.cities, nil
+	return cs.cities, cs.err
+}
+
+func (cs *mockSearcher) SearchWithin(ctx context.Context, query string, area cities.Area, opts cities.SearchOptions) ([]cities.CityWithScore, error) {
+	cs.area = area
+	return cs.Search(ctx, query, opts)
+}
+
+func (cs *mockSearcher) SearchWithLocationWithin(ctx context.Context, query string, lat, lng float64, area cities.Area, opts cities.SearchOptions) ([]cities.CityWithScore, error) {
+	cs.area = area
+	return cs.SearchWithLocation(ctx, query, lat, lng, opts)
 }
 
 func TestQueryParamsValidation(t *testing.T) {
@@ -44,31 +64,157 @@ func TestQueryParamsValidation(t *testing.T) {
 		{
 			name:           "no q set",
 			url:            "/suggestions?",
-			expectedErr:    "q (query string) must be set in URL\n",
+			expectedErr:    `{"error":{"code":"missing_parameter","message":"q (query string) must be set in URL","param":"q"}}` + "\n",
 			expectedStatus: http.StatusBadRequest,
 		},
 		{
 			name:           "lat not number",
 			url:            "/suggestions?q=foo&latitude=a&longitude=0.0",
-			expectedErr:    "latitude/longitude error: latitude was not a number\n",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"latitude was not a number","param":"latitude"}}` + "\n",
 			expectedStatus: http.StatusBadRequest,
 		},
 		{
 			name:           "lng not number",
 			url:            "/suggestions?q=foo&latitude=0.0&longitude=a",
-			expectedErr:    "latitude/longitude error: longitude was not a number\n",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"longitude was not a number","param":"longitude"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "lat out of range",
+			url:            "/suggestions?q=foo&latitude=500&longitude=0.0",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"latitude must be from -90 to 90","param":"latitude"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "lat not a number at all",
+			url:            "/suggestions?q=lon&latitude=NaN&longitude=0",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"latitude must be from -90 to 90","param":"latitude"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "lng out of range",
+			url:            "/suggestions?q=foo&latitude=0.0&longitude=-180.5",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"longitude must be from -180 to 180","param":"longitude"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "lng infinite",
+			url:            "/suggestions?q=foo&latitude=0.0&longitude=Inf",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"longitude must be from -180 to 180","param":"longitude"}}` + "\n",
 			expectedStatus: http.StatusBadRequest,
 		},
 		{
 			name:           "only lat set",
 			url:            "/suggestions?q=foo&latitude=0.0",
-			expectedErr:    "latitude/longitude error: only one angle was provided\n",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"only one angle was provided","param":"longitude"}}` + "\n",
 			expectedStatus: http.StatusBadRequest,
 		},
 		{
 			name:           "only lng set",
 			url:            "/suggestions?q=foo&longitude=0.0",
-			expectedErr:    "latitude/longitude error: only one angle was provided\n",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"only one angle was provided","param":"latitude"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "limit not number",
+			url:            "/suggestions?q=foo&limit=a",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"limit must be a whole number from 1 to 100","param":"limit"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "limit too big",
+			url:            "/suggestions?q=foo&limit=101",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"limit must be a whole number from 1 to 100","param":"limit"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "limit zero",
+			url:            "/suggestions?q=foo&limit=0",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"limit must be a whole number from 1 to 100","param":"limit"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "offset negative",
+			url:            "/suggestions?q=foo&offset=-1",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"offset must be a whole number from 0 to 900","param":"offset"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "offset too big",
+			url:            "/suggestions?q=foo&offset=901",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"offset must be a whole number from 0 to 900","param":"offset"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "country not a country code",
+			url:            "/suggestions?q=foo&country=GB,England",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"country must be a comma separated list of two letter country codes","param":"country"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "empty admin1 code",
+			url:            "/suggestions?q=foo&admin1=ENG,",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"admin1 must be a comma separated list of admin1 codes","param":"admin1"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "unparseable filter",
+			url:            "/suggestions?q=foo&filter=population%3E",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"at position 11: expected a value, got the end of the filter","param":"filter"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "filter nested too deep",
+			url:            "/suggestions?q=foo&filter=" + strings.Repeat("%28", 100),
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"at position 32: brackets and nots nest more than 32 deep","param":"filter"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "bbox and radius",
+			url:            "/suggestions?q=foo&latitude=51&longitude=0&radius_km=5&bbox=-1,50,1,52",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"only one of bbox and radius_km can be set","param":"radius_km"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "bbox too short",
+			url:            "/suggestions?q=foo&bbox=-1,50,1",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"bbox must be four numbers: min longitude, min latitude, max longitude, max latitude","param":"bbox"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "bbox latitudes upside down",
+			url:            "/suggestions?q=foo&bbox=-1,52,1,50",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"bbox latitudes must be from -90 to 90, min first","param":"bbox"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "bbox longitude out of range",
+			url:            "/suggestions?q=foo&bbox=-1,50,181,52",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"bbox longitudes must be from -180 to 180","param":"bbox"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "bbox not a number at all",
+			url:            "/suggestions?q=foo&bbox=NaN,50,1,52",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"bbox longitudes must be from -180 to 180","param":"bbox"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "radius without location",
+			url:            "/suggestions?q=foo&radius_km=5",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"radius_km needs latitude and longitude to be set","param":"radius_km"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "negative radius",
+			url:            "/suggestions?q=foo&latitude=51&longitude=0&radius_km=-5",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"radius_km must be a positive number","param":"radius_km"}}` + "\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "unknown mode",
+			url:            "/suggestions?q=foo&mode=exact",
+			expectedErr:    `{"error":{"code":"invalid_parameter","message":"mode must be fuzzy, prefix or typo","param":"mode"}}` + "\n",
 			expectedStatus: http.StatusBadRequest,
 		},
 	}
@@ -129,6 +275,100 @@ func TestCallsSearcherCorrectly(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "uses default limit",
+			url:  "/suggestions?q=foo",
+			check: func(t *testing.T, s *mockSearcher) {
+				expected := cities.SearchOptions{Limit: 10}
+				if !reflect.DeepEqual(s.opts, expected) {
+					t.Fatalf("expected to call searcher with %+v, but used %+v", expected, s.opts)
+				}
+			},
+		},
+		{
+			name: "passes limit and offset through",
+			url:  "/suggestions?q=foo&latitude=0.1&longitude=0.2&limit=5&offset=20",
+			check: func(t *testing.T, s *mockSearcher) {
+				expected := cities.SearchOptions{Limit: 5, Offset: 20}
+				if !reflect.DeepEqual(s.opts, expected) {
+					t.Fatalf("expected to call searcher with %+v, but used %+v", expected, s.opts)
+				}
+			},
+		},
+		{
+			name: "passes prefix mode through",
+			url:  "/suggestions?q=foo&mode=prefix",
+			check: func(t *testing.T, s *mockSearcher) {
+				if s.opts.Mode != cities.ModePrefix {
+					t.Fatalf("expected to call searcher in prefix mode, but used %+v", s.opts)
+				}
+			},
+		},
+		{
+			name: "passes countries and admin1 through",
+			url:  "/suggestions?q=foo&country=gb,%20IE&admin1=ENG",
+			check: func(t *testing.T, s *mockSearcher) {
+				if !reflect.DeepEqual(s.opts.Countries, []string{"GB", "IE"}) {
+					t.Fatalf("expected to call searcher with countries GB and IE, but used %+v", s.opts)
+				}
+				if !reflect.DeepEqual(s.opts.Admin1, []string{"ENG"}) {
+					t.Fatalf("expected to call searcher with admin1 ENG, but used %+v", s.opts)
+				}
+			},
+		},
+		{
+			name: "passes filter through",
+			url:  "/suggestions?q=foo&filter=population+%3E+5000",
+			check: func(t *testing.T, s *mockSearcher) {
+				if s.opts.Filter == nil {
+					t.Fatalf("expected to call searcher with a filter, but used %+v", s.opts)
+				}
+				if s.opts.Filter(&cities.City{Population: 5000}) || !s.opts.Filter(&cities.City{Population: 5001}) {
+					t.Fatalf("expected the filter to be population > 5000")
+				}
+			},
+		},
+		{
+			name: "passes bbox through",
+			url:  "/suggestions?q=foo&bbox=-1.5,50,1,52.25",
+			check: func(t *testing.T, s *mockSearcher) {
+				expected := cities.BoundingBox{MinLat: 50, MinLng: -1.5, MaxLat: 52.25, MaxLng: 1}
+				if s.area != expected {
+					t.Fatalf("expected to call searcher within %+v, but used %+v", expected, s.area)
+				}
+			},
+		},
+		{
+			name: "passes radius around location through",
+			url:  "/suggestions?q=foo&latitude=51.5&longitude=-0.1&radius_km=25",
+			check: func(t *testing.T, s *mockSearcher) {
+				expected := cities.Circle{Lat: 51.5, Lng: -0.1, RadiusKm: 25}
+				if s.area != expected {
+					t.Fatalf("expected to call searcher within %+v, but used %+v", expected, s.area)
+				}
+				if s.lat != 51.5 || s.lng != -0.1 {
+					t.Fatalf("expected to call searcher with location too, but used %v, %v", s.lat, s.lng)
+				}
+			},
+		},
+		{
+			name: "passes typo mode through",
+			url:  "/suggestions?q=foo&mode=typo",
+			check: func(t *testing.T, s *mockSearcher) {
+				if s.opts.Mode != cities.ModeTypo {
+					t.Fatalf("expected to call searcher in typo mode, but used %+v", s.opts)
+				}
+			},
+		},
+		{
+			name: "passes fuzzy mode through",
+			url:  "/suggestions?q=foo&mode=fuzzy",
+			check: func(t *testing.T, s *mockSearcher) {
+				if s.opts.Mode != cities.ModeFuzzy {
+					t.Fatalf("expected to call searcher in fuzzy mode, but used %+v", s.opts)
+				}
+			},
+		},
 	}
 
 	for _, tt := range cases {
@@ -153,6 +393,81 @@ func TestCallsSearcherCorrectly(t *testing.T) {
 	}
 }
 
+func TestSearchErrors(t *testing.T) {
+	type test struct {
+		name           string
+		err            error
+		expectedErr    string
+		expectedStatus int
+	}
+
+	cases := []test{
+		{
+			name:           "client went away",
+			err:            context.Canceled,
+			expectedErr:    `{"error":{"code":"cancelled","message":"search cancelled: context canceled"}}` + "\n",
+			expectedStatus: 499,
+		},
+		{
+			name:           "ran out of time",
+			err:            fmt.Errorf("wrapped: %w", context.DeadlineExceeded),
+			expectedErr:    `{"error":{"code":"timeout","message":"search timed out: wrapped: context deadline exceeded"}}` + "\n",
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "anything else",
+			err:            errors.New("broken"),
+			expectedErr:    `{"error":{"code":"internal","message":"search failed: broken"}}` + "\n",
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			handle := api.NewCitySearchHandler(&mockSearcher{err: tc.err})
+			rec := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/suggestions?q=foo", nil)
+			if err != nil {
+				t.Fatalf("failure making test request: '%s'", err)
+			}
+			handle(rec, req)
+
+			if rec.Code != tc.expectedStatus {
+				t.Fatalf("expected status %d but got %d", tc.expectedStatus, rec.Code)
+			}
+			body, _ := ioutil.ReadAll(rec.Body)
+			if string(body) != tc.expectedErr {
+				t.Fatalf("expected error '%s', got '%s'", tc.expectedErr, string(body))
+			}
+		})
+	}
+}
+
+func TestErrorsCarryRequestID(t *testing.T) {
+	t.Parallel()
+
+	logger, _ := logtest.NewNullLogger()
+	handle := api.AccessLog(api.NewCitySearchHandler(&mockSearcher{}), logger, api.AccessLogOptions{})
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/suggestions?q=foo&limit=0", nil)
+	req.Header.Set("X-Request-ID", "abc123")
+	handle.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d but got %d", http.StatusBadRequest, rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected a JSON error, got Content-Type %q", ct)
+	}
+	body, _ := ioutil.ReadAll(rec.Body)
+	expected := `{"error":{"code":"invalid_parameter","message":"limit must be a whole number from 1 to 100","param":"limit","request_id":"abc123"}}` + "\n"
+	if string(body) != expected {
+		t.Fatalf("expected error '%s', got '%s'", expected, string(body))
+	}
+}
+
 func TestResponseFormatting(t *testing.T) {
 	type test struct {
 		name           string
@@ -170,21 +485,28 @@ func TestResponseFormatting(t *testing.T) {
 			name: "result includes expected properties",
 			searchResponse: []cities.CityWithScore{{
 				City: cities.City{
-					GeoNameID: "1",
-					Name:      "Wokingham",
-					Lat:       51.4112,
-					Lng:       -0.83565,
+					GeoNameID:   "2633708",
+					Name:        "Wokingham",
+					Lat:         51.4112,
+					Lng:         -0.83565,
+					CountryCode: "GB",
+					Admin1Code:  "ENG",
+					Admin2Code:  "K2",
+					Population:  41143,
+					Timezone:    "Europe/London",
 				},
-				Score: 0.8,
+				MatchedName: "Wokingham",
+				Score:       0.8,
 			}},
-			expectedBody: `{"suggestions":[{"name":"Wokingham","latitude":51.4112,"longitude":-0.83565,"score":0.8}]}`,
+			expectedBody: `{"suggestions":[{"geonameid":"2633708","name":"Wokingham","matched_name":"Wokingham","latitude":51.4112,"longitude":-0.83565,"country_code":"GB","admin1_code":"ENG","admin2_code":"K2","population":41143,"timezone":"Europe/London","score":0.8}]}`,
 		},
 		{
-			name: "result sorted by score",
+			// the searcher ranks results, so the handler leaves them be
+			name: "result keeps the searcher's order",
 			searchResponse: []cities.CityWithScore{
 				{
 					City: cities.City{
-						GeoNameID: "1",
+						GeoNameID: "2633709",
 						Name:      "Woking",
 						Lat:       51.31903,
 						Lng:       -0.55893,
@@ -193,7 +515,7 @@ func TestResponseFormatting(t *testing.T) {
 				},
 				{
 					City: cities.City{
-						GeoNameID: "1",
+						GeoNameID: "2633708",
 						Name:      "Wokingham",
 						Lat:       51.4112,
 						Lng:       -0.83565,
@@ -201,7 +523,7 @@ func TestResponseFormatting(t *testing.T) {
 					Score: 0.8,
 				},
 			},
-			expectedBody: `{"suggestions":[{"name":"Wokingham","latitude":51.4112,"longitude":-0.83565,"score":0.8},{"name":"Woking","latitude":51.31903,"longitude":-0.55893,"score":0.6}]}`,
+			expectedBody: `{"suggestions":[{"geonameid":"2633709","name":"Woking","matched_name":"","latitude":51.31903,"longitude":-0.55893,"country_code":"","admin1_code":"","admin2_code":"","population":0,"timezone":"","score":0.6},{"geonameid":"2633708","name":"Wokingham","matched_name":"","latitude":51.4112,"longitude":-0.83565,"country_code":"","admin1_code":"","admin2_code":"","population":0,"timezone":"","score":0.8}]}`,
 		},
 	}
 
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans the handlers start
+const tracerName = "github.com/oskanberg/citysearch/api"
+
+// traceContext reads and writes the W3C traceparent and tracestate headers
+var traceContext = propagation.TraceContext{}
+
+// Trace starts a server span for every request to next, named after handler,
+// carrying on the trace in the request's W3C trace context headers if there
+// is one. If next is routed to by a Router, the span has its route's
+// pattern. Spans go to tp, and everything started under them (down to the
+// searches) goes to the same place.
+func Trace(handler string, next http.Handler, tp trace.TracerProvider) http.Handler {
+	tracer := tp.Tracer(tracerName)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := traceContext.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		// the URL isn't recorded: its query has the caller's location in it.
+		// Nor is the path, which can have IDs in; the route's pattern can't.
+		attrs := []attribute.KeyValue{semconv.HTTPMethodKey.String(r.Method)}
+		if route := routePattern(r); route != "" {
+			attrs = append(attrs, semconv.HTTPRouteKey.String(route))
+		}
+		ctx, span := tracer.Start(ctx, handler,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attrs...),
+		)
+		defer span.End()
+
+		r, info := withRequestInfo(r.WithContext(ctx))
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		status := rec.status()
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindServer))
+		if info.id != "" {
+			span.SetAttributes(attribute.String("citysearch.request_id", info.id))
+		}
+		if info.results >= 0 {
+			span.SetAttributes(attribute.Int("citysearch.results", info.results))
+		}
+	})
+}
+
+// startSpan starts a span called name under the request's span in ctx, if
+// it is being traced
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName).Start(ctx, name)
+}
@@ -0,0 +1,128 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/oskanberg/citysearch/api"
+	"github.com/oskanberg/citysearch/cities"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+)
+
+func TestTrace(t *testing.T) {
+	t.Parallel()
+
+	cs, err := cities.NewCitySearcher(strings.NewReader("geonameid,name,latitude,longitude\n1,Woking,51.3,-0.5\n2,Wokingham,51.4,-0.8\n"))
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	handle := api.Trace("suggestions", api.NewCitySearchHandler(cs), tp)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/suggestions?q=wok&latitude=51.3&longitude=-0.5", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	handle.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	spans := exporter.GetSpans()
+	byName := make(map[string]tracetest.SpanStub)
+	for _, s := range spans {
+		if s.SpanContext.TraceID().String() != traceID {
+			t.Fatalf("expected span %s to carry on trace %s, got %s", s.Name, traceID, s.SpanContext.TraceID())
+		}
+		byName[s.Name] = s
+	}
+
+	// each span and the one it should be under
+	expected := map[string]string{
+		"suggestions":                     "",
+		"CitySearcher.SearchWithLocation": "suggestions",
+		"candidates":                      "CitySearcher.SearchWithLocation",
+		"score":                           "CitySearcher.SearchWithLocation",
+		"rank":                            "CitySearcher.SearchWithLocation",
+		"encode":                          "suggestions",
+	}
+	if len(spans) != len(expected) {
+		t.Fatalf("expected %d spans, got %d: %v", len(expected), len(spans), byName)
+	}
+	for name, parent := range expected {
+		s, ok := byName[name]
+		if !ok {
+			t.Fatalf("expected a span %s", name)
+		}
+		if parent == "" {
+			if s.Parent.SpanID().String() != "00f067aa0ba902b7" || !s.Parent.IsRemote() {
+				t.Fatalf("expected %s to be under the caller's span, got %s", name, s.Parent.SpanID())
+			}
+			continue
+		}
+		if s.Parent.SpanID() != byName[parent].SpanContext.SpanID() {
+			t.Fatalf("expected %s to be under %s", name, parent)
+		}
+	}
+
+	for _, a := range byName["suggestions"].Attributes {
+		if strings.Contains(a.Value.Emit(), "51.3") {
+			t.Fatalf("expected the location to be left out, got %s=%s", a.Key, a.Value.Emit())
+		}
+	}
+}
+
+func TestTraceFailedSearch(t *testing.T) {
+	t.Parallel()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	handle := api.Trace("suggestions", api.NewCitySearchHandler(&mockSearcher{err: http.ErrAbortHandler}), tp)
+	handle.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/suggestions?q=wok", nil))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	if spans[0].Status.Code != codes.Error {
+		t.Fatalf("expected the span to have failed, got %v", spans[0].Status)
+	}
+	if spans[0].Parent.IsValid() {
+		t.Fatalf("expected a new trace without trace context, got parent %s", spans[0].Parent.SpanID())
+	}
+}
+
+func TestTraceRoute(t *testing.T) {
+	t.Parallel()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	router := api.NewRouter()
+	router.Handle(http.MethodGet, "/v1/cities/{id}", api.Trace("city", named("city", "id"), tp))
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/cities/2633709", nil))
+	// without a router, there's no route to go on
+	api.Trace("city", named("city", "id"), tp).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/cities/2633709", nil))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	for i, expected := range []string{"/v1/cities/{id}", ""} {
+		route := ""
+		for _, a := range spans[i].Attributes {
+			if a.Key == semconv.HTTPRouteKey {
+				route = a.Value.AsString()
+			}
+		}
+		if route != expected {
+			t.Fatalf("expected span %d to have route %q, got %q", i, expected, route)
+		}
+	}
+}
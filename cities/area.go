@@ -0,0 +1,46 @@
+package cities
+
+import (
+	"github.com/umahmood/haversine"
+)
+
+// Area is a region of the globe that searches can be limited to
+type Area interface {
+	// Contains reports whether the point at lat/lng is in the area
+	Contains(lat, lng float64) bool
+}
+
+// BoundingBox is the area between two lines of latitude and two of
+// longitude, such as a map's viewport. If MinLng is greater than MaxLng,
+// the box crosses the antimeridian (180° of longitude).
+type BoundingBox struct {
+	MinLat, MinLng float64
+	MaxLat, MaxLng float64
+}
+
+// Contains implements Area
+func (b BoundingBox) Contains(lat, lng float64) bool {
+	if lat < b.MinLat || lat > b.MaxLat {
+		return false
+	}
+	if b.MinLng <= b.MaxLng {
+		return lng >= b.MinLng && lng <= b.MaxLng
+	}
+	return lng >= b.MinLng || lng <= b.MaxLng
+}
+
+// Circle is the area within RadiusKm of the point at Lat/Lng, measured
+// along the surface of the earth
+type Circle struct {
+	Lat, Lng float64
+	RadiusKm float64
+}
+
+// Contains implements Area
+func (c Circle) Contains(lat, lng float64) bool {
+	_, km := haversine.Distance(
+		haversine.Coord{Lat: c.Lat, Lon: c.Lng},
+		haversine.Coord{Lat: lat, Lon: lng},
+	)
+	return km <= c.RadiusKm
+}
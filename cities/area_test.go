@@ -0,0 +1,96 @@
+package cities_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/oskanberg/citysearch/cities"
+)
+
+func TestAreaContains(t *testing.T) {
+	type test struct {
+		name     string
+		area     cities.Area
+		lat, lng float64
+		expected bool
+	}
+
+	london := cities.Circle{Lat: 51.50853, Lng: -0.12574, RadiusKm: 50}
+	// roughly Fiji, which straddles the antimeridian
+	fiji := cities.BoundingBox{MinLat: -21, MinLng: 176, MaxLat: -12, MaxLng: -178}
+
+	cases := []test{
+		{name: "inside box", area: cities.BoundingBox{MinLat: 50, MinLng: -6, MaxLat: 59, MaxLng: 2}, lat: 51.5, lng: -0.1, expected: true},
+		{name: "on box edge", area: cities.BoundingBox{MinLat: 50, MinLng: -6, MaxLat: 59, MaxLng: 2}, lat: 50, lng: 2, expected: true},
+		{name: "north of box", area: cities.BoundingBox{MinLat: 50, MinLng: -6, MaxLat: 59, MaxLng: 2}, lat: 60, lng: 0, expected: false},
+		{name: "east of box", area: cities.BoundingBox{MinLat: 50, MinLng: -6, MaxLat: 59, MaxLng: 2}, lat: 51, lng: 3, expected: false},
+		{name: "west of the antimeridian", area: fiji, lat: -18, lng: 178, expected: true},
+		{name: "east of the antimeridian", area: fiji, lat: -17, lng: -179, expected: true},
+		{name: "outside a box over the antimeridian", area: fiji, lat: -18, lng: 0, expected: false},
+		{name: "inside circle", area: london, lat: 51.75, lng: -0.34, expected: true}, // St Albans
+		{name: "outside circle", area: london, lat: 52.2, lng: 0.12, expected: false}, // Cambridge
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tc.area.Contains(tc.lat, tc.lng); got != tc.expected {
+				t.Fatalf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestSearchWithin(t *testing.T) {
+	csv := `geonameid,name,latitude,longitude
+1,Newport Pagnell,52.08,-0.72
+2,Newport,51.58,-2.99
+3,Newport,50.70,-1.29
+4,Newport-on-Tay,56.44,-2.94
+`
+
+	cs, err := cities.NewCitySearcher(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	ids := func(results []cities.CityWithScore) []string {
+		var ids []string
+		for _, r := range results {
+			ids = append(ids, r.GeoNameID)
+		}
+		return ids
+	}
+
+	// southern England and Wales
+	box := cities.BoundingBox{MinLat: 50, MinLng: -5, MaxLat: 52, MaxLng: 1}
+	results, err := cs.SearchWithin(context.Background(), "newport", box, cities.SearchOptions{})
+	if err != nil {
+		t.Fatalf("failed to search: %s", err)
+	}
+	if expected := []string{"2", "3"}; !reflect.DeepEqual(ids(results), expected) {
+		t.Fatalf("expected %v, got %v", expected, ids(results))
+	}
+
+	// within 100km of Cardiff, ranked on distance too
+	circle := cities.Circle{Lat: 51.48, Lng: -3.18, RadiusKm: 100}
+	results, err = cs.SearchWithLocationWithin(context.Background(), "newport", 51.48, -3.18, circle, cities.SearchOptions{})
+	if err != nil {
+		t.Fatalf("failed to search: %s", err)
+	}
+	if expected := []string{"2"}; !reflect.DeepEqual(ids(results), expected) {
+		t.Fatalf("expected %v, got %v", expected, ids(results))
+	}
+
+	// nothing there
+	results, err = cs.SearchWithin(context.Background(), "newport", cities.Circle{Lat: 0, Lng: 0, RadiusKm: 10}, cities.SearchOptions{})
+	if err != nil {
+		t.Fatalf("failed to search: %s", err)
+	}
+	if results == nil || len(results) != 0 {
+		t.Fatalf("expected empty results, got %v", results)
+	}
+}
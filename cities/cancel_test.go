@@ -0,0 +1,103 @@
+package cities_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oskanberg/citysearch/cities"
+)
+
+// cancelAfter is a context that is cancelled once its Err has been
+// checked a given number of times, to cancel searches part way through
+type cancelAfter struct {
+	context.Context
+	checks int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.checks <= 0 {
+		return context.Canceled
+	}
+	c.checks--
+	return nil
+}
+
+func TestSearchCancelled(t *testing.T) {
+	cs, err := cities.NewCitySearcher(strings.NewReader(syntheticCSV(20000)))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	expiredCtx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	type search func(ctx context.Context, query string, opts cities.SearchOptions) ([]cities.CityWithScore, error)
+	searches := map[string]search{
+		"Search": cs.Search,
+		"SearchWithLocation": func(ctx context.Context, query string, opts cities.SearchOptions) ([]cities.CityWithScore, error) {
+			return cs.SearchWithLocation(ctx, query, 51.5, -0.1, opts)
+		},
+	}
+	modes := map[string]cities.Mode{"fuzzy": cities.ModeFuzzy, "prefix": cities.ModePrefix, "typo": cities.ModeTypo}
+
+	for searchName, search := range searches {
+		for modeName, mode := range modes {
+			for _, query := range []string{"l", "lon", "glenmra"} {
+				opts := cities.SearchOptions{Limit: 10, Mode: mode}
+				name := searchName + "/" + modeName + "/" + query
+
+				if _, err := search(cancelledCtx, query, opts); !errors.Is(err, context.Canceled) {
+					t.Fatalf("%s: expected context.Canceled, got %v", name, err)
+				}
+				if _, err := search(expiredCtx, query, opts); !errors.Is(err, context.DeadlineExceeded) {
+					t.Fatalf("%s: expected context.DeadlineExceeded, got %v", name, err)
+				}
+
+				// cancelling part way through a search leaves nothing behind
+				// to upset the next one
+				expected, err := search(context.Background(), query, opts)
+				if err != nil {
+					t.Fatalf("%s: failed to search: %s", name, err)
+				}
+				for checks := 1; checks < 5; checks++ {
+					ctx := &cancelAfter{context.Background(), checks}
+					if _, err := search(ctx, query, opts); err != nil && !errors.Is(err, context.Canceled) {
+						t.Fatalf("%s: expected context.Canceled or no error, got %v", name, err)
+					}
+					got, err := search(context.Background(), query, opts)
+					if err != nil {
+						t.Fatalf("%s: failed to search: %s", name, err)
+					}
+					if !reflect.DeepEqual(expected, got) {
+						t.Fatalf("%s: expected results to be unchanged by a cancelled search", name)
+					}
+				}
+			}
+		}
+	}
+
+	if _, err := cs.Nearest(cancelledCtx, 51.5, -0.1, 10); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled from Nearest, got %v", err)
+	}
+}
+
+func TestSearchCancelledPartWay(t *testing.T) {
+	cs, err := cities.NewCitySearcher(strings.NewReader(syntheticCSV(20000)))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	// a single letter matches most of the names, which takes plenty of
+	// checks to get through, so a search cancelled after its first few
+	// must have been stopped in the middle
+	ctx := &cancelAfter{context.Background(), 3}
+	if _, err := cs.Search(ctx, "l", cities.SearchOptions{Limit: 10}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,441 @@
+package cities
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxFilterLength is the longest filter expression ParseFilter takes, in
+// bytes. Filters can come from requests, and each comparison in one is run
+// for every city a search checks, so they're kept to a sensible size.
+const maxFilterLength = 1000
+
+// maxFilterDepth is how deeply brackets and nots can nest in a filter, so
+// that parsing one can't take up an unbounded amount of stack
+const maxFilterDepth = 32
+
+// ParseFilter parses a filter expression into a FilterFunc, so that filters
+// can come from config and requests instead of being compiled in. An
+// expression compares the fields of a city with values, e.g.
+//
+//	country in (GB, IE) and population > 5000 and feature_code != PPLX
+//
+// Comparisons are one of =, !=, <, <=, >, >= or in (a list of values), and
+// can be combined with and, or, not and brackets; and binds tighter than or.
+// Values with spaces or punctuation in can be quoted with " or '. Strings
+// are compared ignoring case, and only numbers can be ordered. The fields
+// are listed in filterFields. Expressions can be at most maxFilterLength
+// bytes long, with brackets and nots nested at most maxFilterDepth deep.
+func ParseFilter(expr string) (FilterFunc, error) {
+	if len(expr) > maxFilterLength {
+		return nil, &ParseError{maxFilterLength, fmt.Sprintf("filter is longer than %d characters", maxFilterLength)}
+	}
+
+	p := &filterParser{lex: filterLexer{src: expr}}
+	p.next()
+	if p.tok.kind == tokEOF {
+		return nil, p.errorf("filter is empty")
+	}
+
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("expected and, or or the end of the filter, got %s", p.tok)
+	}
+	return f, nil
+}
+
+// ParseError is a filter expression that couldn't be parsed
+type ParseError struct {
+	// Pos is the byte offset in the expression the problem was found at
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("at position %d: %s", e.Pos, e.Msg)
+}
+
+// filterField is a field of City that filters can compare. Exactly one of
+// str and num is set, depending on the field's type.
+type filterField struct {
+	str func(c *City) string
+	num func(c *City) float64
+}
+
+// filterFields are the fields a filter can use, named as in the GeoNames
+// readme with underscores for spaces, except for the shorter country
+var filterFields = map[string]filterField{
+	"name":          {str: func(c *City) string { return c.Name }},
+	"asciiname":     {str: func(c *City) string { return c.ASCIIName }},
+	"feature_class": {str: func(c *City) string { return c.FeatureClass }},
+	"feature_code":  {str: func(c *City) string { return c.FeatureCode }},
+	"country":       {str: func(c *City) string { return c.CountryCode }},
+	"cc2":           {str: func(c *City) string { return c.CC2 }},
+	"admin1":        {str: func(c *City) string { return c.Admin1Code }},
+	"admin2":        {str: func(c *City) string { return c.Admin2Code }},
+	"admin3":        {str: func(c *City) string { return c.Admin3Code }},
+	"admin4":        {str: func(c *City) string { return c.Admin4Code }},
+	"timezone":      {str: func(c *City) string { return c.Timezone }},
+	"latitude":      {num: func(c *City) float64 { return c.Lat }},
+	"longitude":     {num: func(c *City) float64 { return c.Lng }},
+	"population":    {num: func(c *City) float64 { return float64(c.Population) }},
+	"elevation":     {num: func(c *City) float64 { return float64(c.Elevation) }},
+	"dem":           {num: func(c *City) float64 { return float64(c.DEM) }},
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "the end of the filter"
+	default:
+		return strconv.Quote(t.text)
+	}
+}
+
+// is reports whether t is the keyword kw, which are case insensitive
+func (t token) is(kw string) bool {
+	return t.kind == tokWord && strings.EqualFold(t.text, kw)
+}
+
+type filterLexer struct {
+	src string
+	pos int
+}
+
+// next returns the next token, or an error for one that's malformed
+func (l *filterLexer) next() (token, error) {
+	for l.pos < len(l.src) && unicode.IsSpace(rune(l.src[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos == len(l.src) {
+		return token{tokEOF, "", start}, nil
+	}
+
+	switch c := l.src[l.pos]; {
+	case c == '(':
+		l.pos++
+		return token{tokLParen, "(", start}, nil
+	case c == ')':
+		l.pos++
+		return token{tokRParen, ")", start}, nil
+	case c == ',':
+		l.pos++
+		return token{tokComma, ",", start}, nil
+	case c == '"' || c == '\'':
+		end := strings.IndexByte(l.src[l.pos+1:], c)
+		if end < 0 {
+			return token{}, &ParseError{start, "unterminated quoted value"}
+		}
+		l.pos += end + 2
+		return token{tokString, l.src[start+1 : l.pos-1], start}, nil
+	case strings.IndexByte("=!<>", c) >= 0:
+		l.pos++
+		if l.pos < len(l.src) && l.src[l.pos] == '=' {
+			l.pos++
+		}
+		op := l.src[start:l.pos]
+		if op == "!" {
+			return token{}, &ParseError{start, "expected != after !"}
+		}
+		return token{tokOp, op, start}, nil
+	default:
+		for l.pos < len(l.src) && isWordByte(l.src[l.pos]) {
+			l.pos++
+		}
+		if l.pos == start {
+			return token{}, &ParseError{start, fmt.Sprintf("unexpected character %q", c)}
+		}
+		return token{tokWord, l.src[start:l.pos], start}, nil
+	}
+}
+
+// isWordByte is whether b can be part of a field name, keyword or unquoted
+// value. Non-ASCII bytes are allowed so that names can be used unquoted.
+func isWordByte(b byte) bool {
+	return b >= 0x80 || b == '_' || b == '.' || b == '-' || b == '+' ||
+		('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9')
+}
+
+// filterParser is a recursive descent parser over the filter grammar:
+//
+//	or         = and { "or" and }
+//	and        = unary { "and" unary }
+//	unary      = "not" unary | "(" or ")" | comparison
+//	comparison = field op value | field [ "not" ] "in" "(" value { "," value } ")"
+type filterParser struct {
+	lex filterLexer
+	tok token
+	err error
+	// depth is how many brackets and nots the current token is inside
+	depth int
+}
+
+// next moves on to the next token, keeping the first lexing error
+func (p *filterParser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.next()
+	if p.err != nil {
+		p.tok = token{kind: tokEOF, pos: p.lex.pos}
+	}
+}
+
+// errorf returns a ParseError at the current token, unless lexing
+// already failed, in which case that is the more useful error
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+	return &ParseError{p.tok.pos, fmt.Sprintf(format, args...)}
+}
+
+func (p *filterParser) or() (FilterFunc, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.is("or") {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(c *City) bool { return l(c) || right(c) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) and() (FilterFunc, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.is("and") {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(c *City) bool { return l(c) && right(c) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) unary() (FilterFunc, error) {
+	if p.tok.is("not") || p.tok.kind == tokLParen {
+		if p.depth == maxFilterDepth {
+			return nil, p.errorf("brackets and nots nest more than %d deep", maxFilterDepth)
+		}
+		p.depth++
+		defer func() { p.depth-- }()
+	}
+
+	switch {
+	case p.tok.is("not"):
+		p.next()
+		f, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(c *City) bool { return !f(c) }, nil
+	case p.tok.kind == tokLParen:
+		p.next()
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.errorf("expected ), got %s", p.tok)
+		}
+		p.next()
+		return f, nil
+	default:
+		return p.comparison()
+	}
+}
+
+func (p *filterParser) comparison() (FilterFunc, error) {
+	if p.tok.kind != tokWord {
+		return nil, p.errorf("expected a field name, got %s", p.tok)
+	}
+	field, ok := filterFields[strings.ToLower(p.tok.text)]
+	if !ok {
+		return nil, p.errorf("unknown field %q", p.tok.text)
+	}
+	p.next()
+
+	negate := false
+	if p.tok.is("not") {
+		negate = true
+		p.next()
+		if !p.tok.is("in") {
+			return nil, p.errorf("expected in after not, got %s", p.tok)
+		}
+	}
+	if p.tok.is("in") {
+		p.next()
+		f, err := p.in(field)
+		if err != nil {
+			return nil, err
+		}
+		if negate {
+			return func(c *City) bool { return !f(c) }, nil
+		}
+		return f, nil
+	}
+
+	if p.tok.kind != tokOp {
+		return nil, p.errorf("expected a comparison (=, !=, <, <=, >, >= or in), got %s", p.tok)
+	}
+	op := p.tok
+	p.next()
+
+	if field.num != nil {
+		v, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		return compareNum(field.num, op.text, v), nil
+	}
+
+	if op.text != "=" && op.text != "==" && op.text != "!=" {
+		return nil, &ParseError{op.pos, fmt.Sprintf("%s can only be used with numeric fields", op.text)}
+	}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if op.text == "!=" {
+		return func(c *City) bool { return !strings.EqualFold(field.str(c), v) }, nil
+	}
+	return func(c *City) bool { return strings.EqualFold(field.str(c), v) }, nil
+}
+
+// in parses the bracketed list of values after in
+func (p *filterParser) in(field filterField) (FilterFunc, error) {
+	if p.tok.kind != tokLParen {
+		return nil, p.errorf("expected ( after in, got %s", p.tok)
+	}
+	p.next()
+
+	var strs []string
+	var nums []float64
+	for {
+		if field.num != nil {
+			v, err := p.number()
+			if err != nil {
+				return nil, err
+			}
+			nums = append(nums, v)
+		} else {
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			strs = append(strs, v)
+		}
+
+		if p.tok.kind == tokRParen {
+			p.next()
+			break
+		}
+		if p.tok.kind != tokComma {
+			return nil, p.errorf("expected , or ), got %s", p.tok)
+		}
+		p.next()
+	}
+
+	if field.num != nil {
+		return func(c *City) bool {
+			n := field.num(c)
+			for _, v := range nums {
+				if n == v {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	return func(c *City) bool {
+		s := field.str(c)
+		for _, v := range strs {
+			if strings.EqualFold(s, v) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// value parses a quoted or unquoted value
+func (p *filterParser) value() (string, error) {
+	if p.tok.kind != tokWord && p.tok.kind != tokString {
+		return "", p.errorf("expected a value, got %s", p.tok)
+	}
+	v := p.tok.text
+	p.next()
+	return v, nil
+}
+
+// number parses a value that must be numeric
+func (p *filterParser) number() (float64, error) {
+	tok := p.tok
+	v, err := p.value()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, &ParseError{tok.pos, fmt.Sprintf("expected a number, got %s", tok)}
+	}
+	return n, nil
+}
+
+// compareNum returns a FilterFunc comparing the field got from get to v.
+// op must be one the lexer produces.
+func compareNum(get func(c *City) float64, op string, v float64) FilterFunc {
+	switch op {
+	case "=", "==":
+		return func(c *City) bool { return get(c) == v }
+	case "!=":
+		return func(c *City) bool { return get(c) != v }
+	case "<":
+		return func(c *City) bool { return get(c) < v }
+	case "<=":
+		return func(c *City) bool { return get(c) <= v }
+	case ">":
+		return func(c *City) bool { return get(c) > v }
+	default:
+		return func(c *City) bool { return get(c) >= v }
+	}
+}
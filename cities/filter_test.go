@@ -0,0 +1,197 @@
+package cities_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/oskanberg/citysearch/cities"
+)
+
+func TestParseFilter(t *testing.T) {
+	london := cities.City{Name: "London", CountryCode: "GB", Admin1Code: "ENG", FeatureCode: "PPLC", Population: 7556900, Lat: 51.5}
+	dublin := cities.City{Name: "Dublin", CountryCode: "IE", Admin1Code: "L", FeatureCode: "PPLC", Population: 1024027, Lat: 53.3}
+	kensington := cities.City{Name: "Kensington", CountryCode: "GB", Admin1Code: "ENG", FeatureCode: "PPLX", Population: 64681, Lat: 51.5}
+	stDavids := cities.City{Name: "St Davids", CountryCode: "GB", Admin1Code: "WLS", FeatureCode: "PPL", Population: 1841, Lat: 51.9}
+	munich := cities.City{Name: "Munich", CountryCode: "DE", Admin1Code: "02", FeatureCode: "PPLA", Population: 1260391, Lat: 48.1}
+	all := []cities.City{london, dublin, kensington, stDavids, munich}
+
+	type test struct {
+		name     string
+		expr     string
+		expected []string
+	}
+
+	cases := []test{
+		{
+			name:     "the example",
+			expr:     "country in (GB,IE) and population > 5000 and feature_code != PPLX",
+			expected: []string{"London", "Dublin"},
+		},
+		{
+			name:     "equals ignores case",
+			expr:     "country = gb",
+			expected: []string{"London", "Kensington", "St Davids"},
+		},
+		{
+			name:     "nested as deep as allowed",
+			expr:     strings.Repeat("(", 31) + "not country = GB" + strings.Repeat(")", 31),
+			expected: []string{"Dublin", "Munich"},
+		},
+		{
+			name:     "or binds looser than and",
+			expr:     "country = DE or country = GB and admin1 == WLS",
+			expected: []string{"St Davids", "Munich"},
+		},
+		{
+			name:     "brackets",
+			expr:     "(country = DE or country = GB) and admin1 = WLS",
+			expected: []string{"St Davids"},
+		},
+		{
+			name:     "not",
+			expr:     "not country in (GB, IE) and NOT (population < 1000000)",
+			expected: []string{"Munich"},
+		},
+		{
+			name:     "not in",
+			expr:     "country not in (GB)",
+			expected: []string{"Dublin", "Munich"},
+		},
+		{
+			name:     "quoted values",
+			expr:     `name = "st davids" or name = 'Munich'`,
+			expected: []string{"St Davids", "Munich"},
+		},
+		{
+			name:     "numeric comparisons",
+			expr:     "latitude >= 51.9 and population <= 1024027 and elevation = 0",
+			expected: []string{"Dublin", "St Davids"},
+		},
+		{
+			name:     "numeric in",
+			expr:     "population in (1841, 64681)",
+			expected: []string{"Kensington", "St Davids"},
+		},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			f, err := cities.ParseFilter(tc.expr)
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+
+			var names []string
+			for _, c := range cities.Filter(all, f) {
+				names = append(names, c.Name)
+			}
+			if len(names) != len(tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, names)
+			}
+			for i := range names {
+				if names[i] != tc.expected[i] {
+					t.Fatalf("expected %v, got %v", tc.expected, names)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	type test struct {
+		name     string
+		expr     string
+		expected string
+	}
+
+	cases := []test{
+		{
+			name:     "empty",
+			expr:     "  ",
+			expected: "at position 2: filter is empty",
+		},
+		{
+			name:     "unknown field",
+			expr:     "country = GB and size > 3",
+			expected: `at position 17: unknown field "size"`,
+		},
+		{
+			name:     "missing operator",
+			expr:     "country GB",
+			expected: `at position 8: expected a comparison (=, !=, <, <=, >, >= or in), got "GB"`,
+		},
+		{
+			name:     "ordering strings",
+			expr:     "country < GB",
+			expected: "at position 8: < can only be used with numeric fields",
+		},
+		{
+			name:     "not a number",
+			expr:     "population > lots",
+			expected: `at position 13: expected a number, got "lots"`,
+		},
+		{
+			name:     "unclosed list",
+			expr:     "country in (GB, IE",
+			expected: "at position 18: expected , or ), got the end of the filter",
+		},
+		{
+			name:     "unclosed bracket",
+			expr:     "(country = GB",
+			expected: "at position 13: expected ), got the end of the filter",
+		},
+		{
+			name:     "unterminated quote",
+			expr:     `name = "St Davids`,
+			expected: "at position 7: unterminated quoted value",
+		},
+		{
+			name:     "stray character at the end",
+			expr:     "country = GB;",
+			expected: `at position 12: unexpected character ';'`,
+		},
+		{
+			name:     "trailing words",
+			expr:     "country = GB IE",
+			expected: `at position 13: expected and, or or the end of the filter, got "IE"`,
+		},
+		{
+			name:     "lone !",
+			expr:     "country ! GB",
+			expected: "at position 8: expected != after !",
+		},
+		{
+			name:     "nested too deep",
+			expr:     strings.Repeat("(", 32) + "not country = GB" + strings.Repeat(")", 32),
+			expected: "at position 32: brackets and nots nest more than 32 deep",
+		},
+		{
+			name:     "unbounded nesting",
+			expr:     strings.Repeat("(", 512*1024),
+			expected: "at position 1000: filter is longer than 1000 characters",
+		},
+		{
+			name:     "too long",
+			expr:     "country in (" + strings.Repeat("GB, ", 250) + "IE)",
+			expected: "at position 1000: filter is longer than 1000 characters",
+		},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := cities.ParseFilter(tc.expr)
+			var perr *cities.ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("expected a ParseError, got %v", err)
+			}
+			if err.Error() != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, err.Error())
+			}
+		})
+	}
+}
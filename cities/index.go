@@ -0,0 +1,504 @@
+package cities
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// gramSize is the length (in runes) of the n-grams used to index names
+const gramSize = 3
+
+// maxCandidates caps how many names sharing grams with the query are
+// passed on to the (comparatively expensive) fuzzy scoring
+const maxCandidates = 1000
+
+// nameIndex is an inverted index from trigrams to the positions of the
+// names containing them. It lets Search pick a small set of likely
+// candidates instead of fuzzy matching every name it knows about.
+// A city is indexed under each of its names, so one city can have
+// several positions in the index. The cities are indexed in order, and
+// must be the most populous first, so that the names of bigger places
+// have lower positions.
+type nameIndex struct {
+	names []string
+	// refs says which city (and which of its names) each of names is
+	refs []nameRef
+	// countries partitions the index by the country code (in upper case)
+	// of each name's city, so that searches limited to a few countries
+	// only look through their names
+	countries map[string]*partition
+	// partitions is every one of countries, for searching them all
+	partitions []*partition
+	// byPopulation is every position in the index in order, so the names of
+	// the most populous cities first, for choosing between more matches
+	// than can all be scored
+	byPopulation []int32
+	// letters has a bit set for each rune in each name, to rule most names
+	// out of a scan without looking at them
+	letters []uint64
+	// prefixes is sorted, for finding names with a word starting with the query
+	prefixes []prefixEntry
+	// typos is for finding names within a few typos of the query
+	typos *typoIndex
+
+	// counters holds *gramCounts, for counting gram hits without allocating
+	// on every query
+	counters sync.Pool
+}
+
+// gramCounts is scratch space for counting how many of the query's grams
+// each name has
+type gramCounts struct {
+	// counts has one slot per name
+	counts []uint16
+	// touched are the names whose counts aren't 0
+	touched []int32
+}
+
+// partition is the part of the index for one country's names. The
+// partitions share the names (and everything else) with the index.
+type partition struct {
+	// postings are from each gram to the positions of the names containing it
+	postings map[string][]int32
+	// byPopulation is every position in the partition in order, so the
+	// names of the most populous cities first
+	byPopulation []int32
+}
+
+// nameRef points from a position in the index back to a city
+type nameRef struct {
+	// city is the city's position in the slice the index was built from
+	city int32
+	// variant is the position of the name in the city's Variants
+	variant int32
+}
+
+// match is a single name that matched a query, and how far away it was
+type match struct {
+	id       int32
+	distance int
+}
+
+// newNameIndex builds the index over every name of every city
+func newNameIndex(cities []City) *nameIndex {
+	var names []string
+	var refs []nameRef
+	for i, c := range cities {
+		// most cities list their name again among the alternates,
+		// so only index each distinct (normalised) name once
+		seen := make(map[string]bool)
+		for j, v := range c.Variants() {
+			n := Normalise(v)
+			if n == "" || seen[n] {
+				continue
+			}
+			seen[n] = true
+			names = append(names, n)
+			refs = append(refs, nameRef{int32(i), int32(j)})
+		}
+	}
+	return buildNameIndex(cities, names, refs)
+}
+
+// buildNameIndex builds the index over names, which must already be
+// normalised, each belonging to the city in cities given by the matching ref
+func buildNameIndex(cities []City, names []string, refs []nameRef) *nameIndex {
+	idx := &nameIndex{
+		names:     names,
+		refs:      refs,
+		countries: make(map[string]*partition),
+	}
+
+	idx.counters.New = func() interface{} { return &gramCounts{counts: make([]uint16, len(idx.names))} }
+
+	// parts is the partition each name is in
+	parts := make([]*partition, len(idx.names))
+	for i, ref := range idx.refs {
+		cc := strings.ToUpper(cities[ref.city].CountryCode)
+		if idx.countries[cc] == nil {
+			idx.countries[cc] = &partition{postings: make(map[string][]int32)}
+			idx.partitions = append(idx.partitions, idx.countries[cc])
+		}
+		parts[i] = idx.countries[cc]
+	}
+
+	for i, n := range idx.names {
+		// names like "Hull" contain the same gram more than once,
+		// but each name should only appear once per posting list
+		seen := make(map[string]bool)
+		for _, g := range grams(n, true) {
+			if seen[g] {
+				continue
+			}
+			seen[g] = true
+			parts[i].postings[g] = append(parts[i].postings[g], int32(i))
+		}
+	}
+
+	idx.letters = make([]uint64, len(idx.names))
+	for i, n := range idx.names {
+		idx.letters[i] = letterSet(n)
+	}
+
+	idx.byPopulation = make([]int32, len(idx.names))
+	for i := range idx.byPopulation {
+		idx.byPopulation[i] = int32(i)
+		parts[i].byPopulation = append(parts[i].byPopulation, int32(i))
+	}
+
+	idx.buildPrefixes()
+	idx.typos = newTypoIndex(idx.names)
+
+	return idx
+}
+
+// in returns the partitions for countries, or all of them if there are
+// none. Countries with no names have no partition.
+func (idx *nameIndex) in(countries []string) []*partition {
+	if len(countries) == 0 {
+		return idx.partitions
+	}
+
+	var parts []*partition
+	seen := make(map[*partition]bool)
+	for _, cc := range countries {
+		if p := idx.countries[strings.ToUpper(cc)]; p != nil && !seen[p] {
+			seen[p] = true
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+// populationOrder returns every position in parts, the names of the most
+// populous cities first
+func (idx *nameIndex) populationOrder(parts []*partition) []int32 {
+	switch len(parts) {
+	case len(idx.partitions):
+		return idx.byPopulation
+	case 1:
+		return parts[0].byPopulation
+	}
+
+	// each partition is in order already, so merge them
+	var n int
+	for _, p := range parts {
+		n += len(p.byPopulation)
+	}
+	order := make([]int32, 0, n)
+	next := make([]int, len(parts))
+	for len(order) < n {
+		var best int32 = -1
+		var from int
+		for i, p := range parts {
+			if next[i] == len(p.byPopulation) {
+				continue
+			}
+			if id := p.byPopulation[next[i]]; best < 0 || id < best {
+				best, from = id, i
+			}
+		}
+		order = append(order, best)
+		next[from]++
+	}
+	return order
+}
+
+// grams splits s into overlapping trigrams. The start is padded so that
+// names sharing their first letters with the query are favoured; the end
+// is only padded for indexed names, since queries are usually incomplete.
+// There is deliberately no gram for the first letter alone: it would match
+// a big slice of the index while saying very little about the query.
+func grams(s string, padEnd bool) []string {
+	padded := " " + s
+	if padEnd {
+		padded += " "
+	}
+
+	r := []rune(padded)
+	if len(r) < gramSize {
+		return nil
+	}
+
+	g := make([]string, 0, len(r)-gramSize+1)
+	for i := 0; i+gramSize <= len(r); i++ {
+		g = append(g, string(r[i:i+gramSize]))
+	}
+	return g
+}
+
+// indexable is whether query is long enough to be looked up in the index,
+// rather than the names being scanned
+func (idx *nameIndex) indexable(query string) bool {
+	return utf8.RuneCountInString(query) >= gramSize
+}
+
+// candidates returns the positions of the names in parts sharing the most
+// grams with query, up to maxCandidates. Of names sharing as many, those of
+// the most populous cities are taken first. Names keep (if not nil) returns
+// false for are never taken, so they don't take the place of any that
+// would be. It returns false if the query is too short to be looked up, in
+// which case the names must be scanned.
+func (idx *nameIndex) candidates(ctx context.Context, query string, parts []*partition, keep func(id int32) bool) ([]int32, bool, error) {
+	if !idx.indexable(query) {
+		return nil, false, nil
+	}
+
+	// rejected is the count of a name keep returned false for
+	const rejected = math.MaxUint16
+
+	qGrams := grams(query, false)
+	if len(qGrams) >= rejected {
+		qGrams = qGrams[:rejected-1]
+	}
+
+	// each gram's names are spread over the partitions
+	hits := make([]gramHits, len(qGrams))
+	for k, g := range qGrams {
+		for _, p := range parts {
+			if ids := p.postings[g]; len(ids) > 0 {
+				hits[k].postings = append(hits[k].postings, ids)
+				hits[k].n += len(ids)
+			}
+		}
+	}
+
+	// the rarest grams say the most about the query, so go through them
+	// first: the names they turn up soon share more grams than are left
+	sort.Slice(hits, func(i, j int) bool { return hits[i].n < hits[j].n })
+
+	scratch := idx.counters.Get().(*gramCounts)
+	counts, touched := scratch.counts, scratch.touched[:0]
+	defer func() {
+		// the scratch counts must go back clean
+		for _, id := range touched {
+			counts[id] = 0
+		}
+		scratch.touched = touched[:0]
+		idx.counters.Put(scratch)
+	}()
+
+	// sharing[c] is how many names have been seen in exactly c grams. Once
+	// maxCandidates of them share more grams than are left, a name not seen
+	// yet can't beat them, so from then on only the names seen are counted.
+	// Names keep rules out are never counted, or they could stop names it
+	// doesn't rule out being seen.
+	sharing := make([]int, len(qGrams)+1)
+	steps := 0
+	for k, h := range hits {
+		better := 0
+		for c := len(qGrams) - k + 1; c < len(sharing); c++ {
+			better += sharing[c]
+		}
+		admitting := better < maxCandidates
+
+		for _, ids := range h.postings {
+			for _, id := range ids {
+				if err := cancelled(ctx, steps); err != nil {
+					return nil, false, err
+				}
+				steps++
+				c := counts[id]
+				if c == rejected {
+					continue
+				}
+				if c == 0 {
+					if !admitting {
+						continue
+					}
+					touched = append(touched, id)
+					if keep != nil && !keep(id) {
+						counts[id] = rejected
+						continue
+					}
+				}
+				sharing[c]--
+				counts[id]++
+				sharing[c+1]++
+			}
+		}
+	}
+
+	// sharing now says how many names have each count, so the lowest count
+	// taken can be found without sorting by count. Names with more are all
+	// taken, and those with exactly as many fill the room left.
+	cut, room := 0, maxCandidates
+	for c := len(sharing) - 1; c > 0; c-- {
+		if sharing[c] >= room {
+			cut = c
+			break
+		}
+		room -= sharing[c]
+	}
+
+	ids := make([]int32, 0, maxCandidates)
+	var ties []int32
+	if cut > 0 {
+		ties = make([]int32, 0, sharing[cut])
+	}
+	for _, id := range touched {
+		switch c := int(counts[id]); {
+		case c == rejected:
+		case c > cut:
+			ids = append(ids, id)
+		case c == cut:
+			ties = append(ties, id)
+		}
+	}
+	if len(ties) > room {
+		// only some of them fit, so take the biggest places
+		selectBiggest(ties, room)
+		ties = ties[:room]
+	}
+	ids = append(ids, ties...)
+	return ids, true, nil
+}
+
+// selectBiggest moves the k names of the most populous cities in ids (the
+// lowest positions) to the front of it, in no particular order. Only the
+// front is wanted, so this is quicker than sorting them all.
+func selectBiggest(ids []int32, k int) {
+	// quickselect, which falls back to sorting if the pivots are unlucky
+	lo, hi := 0, len(ids)
+	for rounds := 0; hi-lo > 1; rounds++ {
+		if rounds > 64 {
+			part := ids[lo:hi]
+			sort.Slice(part, func(i, j int) bool { return part[i] < part[j] })
+			return
+		}
+
+		// move the pivot (the middle one) to the end, and everything
+		// before it in front of where it belongs
+		mid := lo + (hi-lo)/2
+		ids[mid], ids[hi-1] = ids[hi-1], ids[mid]
+		pivot, at := ids[hi-1], lo
+		for i := lo; i < hi-1; i++ {
+			if ids[i] < pivot {
+				ids[i], ids[at] = ids[at], ids[i]
+				at++
+			}
+		}
+		ids[at], ids[hi-1] = ids[hi-1], ids[at]
+
+		switch {
+		case at == k || at == k-1:
+			return
+		case at > k:
+			hi = at
+		default:
+			lo = at + 1
+		}
+	}
+}
+
+// gramHits are the names containing one of the query's grams
+type gramHits struct {
+	// postings are the gram's posting lists in each partition searched
+	postings [][]int32
+	// n is how many names there are in postings
+	n int
+}
+
+// find returns candidate names in parts that fuzzy match query (i.e.
+// contain its characters in order), with their Levenshtein distance from
+// the query, leaving out those keep (if not nil) returns false for. The
+// query must be normalised. Matches are ordered best first, ties broken by
+// position in the index.
+func (idx *nameIndex) find(ctx context.Context, query string, parts []*partition, keep func(id int32) bool) ([]match, error) {
+	ids, ok, err := idx.candidates(ctx, query, parts, keep)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return idx.scan(ctx, query, parts, keep)
+	}
+
+	var matches []match
+	for _, id := range ids {
+		if d := subsequenceDistance(query, idx.names[id]); d >= 0 {
+			matches = append(matches, match{id, d})
+		}
+	}
+	if len(matches) == 0 {
+		// a query can be in a name without sharing a gram with it, like
+		// "ldn" in London, so when the grams find nothing look further
+		return idx.scan(ctx, query, parts, keep)
+	}
+
+	sortMatches(matches)
+	return matches, nil
+}
+
+// scan fuzzy matches query against every name in parts, as find does, for
+// queries the grams are no help with. A letter or two matches a good part
+// of every name there is, so it stops at maxCandidates matches, going
+// through the names of the most populous cities first.
+func (idx *nameIndex) scan(ctx context.Context, query string, parts []*partition, keep func(id int32) bool) ([]match, error) {
+	letters := letterSet(query)
+	var matches []match
+	for i, id := range idx.populationOrder(parts) {
+		if err := cancelled(ctx, i); err != nil {
+			return nil, err
+		}
+		if idx.letters[id]&letters != letters || (keep != nil && !keep(id)) {
+			continue
+		}
+		if d := subsequenceDistance(query, idx.names[id]); d >= 0 {
+			matches = append(matches, match{id, d})
+			if len(matches) == maxCandidates {
+				break
+			}
+		}
+	}
+
+	sortMatches(matches)
+	return matches, nil
+}
+
+// letterSet has a bit set for each rune in s. Runes share bits, so a name
+// whose set lacks one of the query's can't contain the query, but one whose
+// set has them all still might not.
+func letterSet(s string) uint64 {
+	var set uint64
+	for _, r := range s {
+		set |= 1 << (uint(r) % 64)
+	}
+	return set
+}
+
+// sortMatches orders matches best first, ties broken by position in the index
+func sortMatches(matches []match) {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].id < matches[j].id
+	})
+}
+
+// subsequenceDistance returns how many runes of target had to be skipped to
+// find all of query's runes in order, or -1 if they are not all there. For
+// a subsequence, that is the same as the Levenshtein distance between the
+// two, but much cheaper to get.
+func subsequenceDistance(query, target string) int {
+	skipped := 0
+	for _, qr := range query {
+		found := false
+		for i, tr := range target {
+			if tr == qr {
+				target = target[i+utf8.RuneLen(tr):]
+				found = true
+				break
+			}
+			skipped++
+		}
+		if !found {
+			return -1
+		}
+	}
+	return skipped + utf8.RuneCountInString(target)
+}
@@ -0,0 +1,136 @@
+package cities
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// earthRadiusKm is the mean radius of the earth, as haversine uses
+const earthRadiusKm = 6371
+
+// kdTree is a k-d tree over the positions of cities as points on the unit
+// sphere. Working in 3D avoids the trouble latitude and longitude have at
+// the poles and the antimeridian, and straight line (chord) distance
+// between points on the sphere orders them the same as distance along it.
+// The tree is stored implicitly: each range of points is split at its
+// middle point, whose left and right halves are the subtrees.
+type kdTree struct {
+	points []kdPoint
+}
+
+type kdPoint struct {
+	pos  vec3
+	city int32
+}
+
+type vec3 [3]float64
+
+// toVec3 is the position of lat/lng (in degrees) on the unit sphere
+func toVec3(lat, lng float64) vec3 {
+	φ, λ := lat*math.Pi/180, lng*math.Pi/180
+	return vec3{math.Cos(φ) * math.Cos(λ), math.Cos(φ) * math.Sin(λ), math.Sin(φ)}
+}
+
+func (a vec3) dist2(b vec3) float64 {
+	x, y, z := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return x*x + y*y + z*z
+}
+
+// chordToKm turns the squared chord distance between two points on the
+// unit sphere into the distance along the surface of the earth
+func chordToKm(dist2 float64) float64 {
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(dist2)/2))
+}
+
+// newKDTree builds the tree over every city
+func newKDTree(cities []City) *kdTree {
+	t := &kdTree{points: make([]kdPoint, len(cities))}
+	for i, c := range cities {
+		t.points[i] = kdPoint{toVec3(c.Lat, c.Lng), int32(i)}
+	}
+	t.build(t.points, 0)
+	return t
+}
+
+// build arranges points so that the middle one splits the rest on the
+// axis for depth, then does the same for each half
+func (t *kdTree) build(points []kdPoint, depth int) {
+	if len(points) <= 1 {
+		return
+	}
+	axis := depth % 3
+	sort.Slice(points, func(i, j int) bool { return points[i].pos[axis] < points[j].pos[axis] })
+	mid := len(points) / 2
+	t.build(points[:mid], depth+1)
+	t.build(points[mid+1:], depth+1)
+}
+
+// nearest returns the k cities closest to pos, closest first, with their
+// squared chord distances. Cities that keep returns false for are skipped.
+func (t *kdTree) nearest(pos vec3, k int, keep func(city int32) bool) []kdNeighbour {
+	if k <= 0 {
+		return nil
+	}
+	h := &kdHeap{}
+	t.search(t.points, 0, pos, k, keep, h)
+
+	out := []kdNeighbour(*h)
+	sort.Slice(out, func(i, j int) bool { return out[i].dist2 < out[j].dist2 })
+	return out
+}
+
+func (t *kdTree) search(points []kdPoint, depth int, pos vec3, k int, keep func(int32) bool, h *kdHeap) {
+	if len(points) == 0 {
+		return
+	}
+	mid := len(points) / 2
+	p := points[mid]
+
+	if keep == nil || keep(p.city) {
+		d := p.pos.dist2(pos)
+		if h.Len() < k {
+			// appending and fixing, rather than heap.Push, saves boxing
+			// every neighbour in an interface
+			*h = append(*h, kdNeighbour{p.city, d})
+			heap.Fix(h, h.Len()-1)
+		} else if d < (*h)[0].dist2 {
+			(*h)[0] = kdNeighbour{p.city, d}
+			heap.Fix(h, 0)
+		}
+	}
+
+	// the side of the split pos is on is the likeliest to have the closest,
+	// so search it first; the other only if it could beat the worst so far
+	axis := depth % 3
+	diff := pos[axis] - p.pos[axis]
+	near, far := points[:mid], points[mid+1:]
+	if diff > 0 {
+		near, far = far, near
+	}
+	t.search(near, depth+1, pos, k, keep, h)
+	if h.Len() < k || diff*diff < (*h)[0].dist2 {
+		t.search(far, depth+1, pos, k, keep, h)
+	}
+}
+
+// kdNeighbour is a city found near a point, and its squared chord distance
+type kdNeighbour struct {
+	city  int32
+	dist2 float64
+}
+
+// kdHeap is a max heap of neighbours on distance, so the furthest of the
+// closest found so far is always to hand to be replaced
+type kdHeap []kdNeighbour
+
+func (h kdHeap) Len() int            { return len(h) }
+func (h kdHeap) Less(i, j int) bool  { return h[i].dist2 > h[j].dist2 }
+func (h kdHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *kdHeap) Push(x interface{}) { *h = append(*h, x.(kdNeighbour)) }
+func (h *kdHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,142 @@
+package cities_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/oskanberg/citysearch/cities"
+	"github.com/umahmood/haversine"
+)
+
+func TestNearest(t *testing.T) {
+	csv := `geonameid,name,latitude,longitude
+1,London,51.50853,-0.12574
+2,Watford,51.65531,-0.39602
+3,St Albans,51.75,-0.33333
+4,Cambridge,52.2,0.11667
+5,Suva,-18.14161,178.44149
+6,Apia,-13.83333,-171.76666
+`
+
+	type test struct {
+		name     string
+		lat, lng float64
+		k        int
+		expected []string
+	}
+
+	cases := []test{
+		{name: "closest first", lat: 51.66, lng: -0.4, k: 3, expected: []string{"Watford", "St Albans", "London"}},
+		{name: "k of one", lat: 52.1, lng: 0.1, k: 1, expected: []string{"Cambridge"}},
+		{name: "k of zero", lat: 52.1, lng: 0.1, k: 0, expected: []string{}},
+		{name: "k more than there are", lat: 0, lng: 0, k: 10, expected: []string{"London", "Watford", "St Albans", "Cambridge", "Suva", "Apia"}},
+		{name: "across the antimeridian", lat: -15, lng: -179, k: 2, expected: []string{"Suva", "Apia"}},
+	}
+
+	cs, err := cities.NewCitySearcher(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			result, err := cs.Nearest(context.Background(), tc.lat, tc.lng, tc.k)
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+
+			names := []string{}
+			for _, r := range result {
+				names = append(names, r.Name)
+
+				_, km := haversine.Distance(haversine.Coord{Lat: tc.lat, Lon: tc.lng}, haversine.Coord{Lat: r.Lat, Lon: r.Lng})
+				if math.Abs(km-r.DistanceKm) > 0.001 {
+					t.Fatalf("expected %s to be %vkm away, got %vkm", r.Name, km, r.DistanceKm)
+				}
+			}
+			if !reflect.DeepEqual(tc.expected, names) {
+				t.Fatalf("expected %v, got %v", tc.expected, names)
+			}
+		})
+	}
+}
+
+// randomCoords are n points scattered evenly over the globe
+func randomCoords(n int) []haversine.Coord {
+	r := rand.New(rand.NewSource(1))
+	coords := make([]haversine.Coord, n)
+	for i := range coords {
+		coords[i] = haversine.Coord{
+			Lat: math.Asin(2*r.Float64()-1) * 180 / math.Pi,
+			Lon: r.Float64()*360 - 180,
+		}
+	}
+	return coords
+}
+
+// citiesCSV has a city at each of coords
+func citiesCSV(coords []haversine.Coord) string {
+	var sb strings.Builder
+	sb.WriteString("geonameid,name,latitude,longitude\n")
+	for i, c := range coords {
+		fmt.Fprintf(&sb, "%d,City %d,%v,%v\n", i, i, c.Lat, c.Lon)
+	}
+	return sb.String()
+}
+
+func TestNearestMatchesBruteForce(t *testing.T) {
+	coords := randomCoords(5000)
+	cs, err := cities.NewCitySearcher(strings.NewReader(citiesCSV(coords)))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 50; i++ {
+		lat, lng := r.Float64()*180-90, r.Float64()*360-180
+
+		distances := make([]float64, len(coords))
+		for j, c := range coords {
+			_, distances[j] = haversine.Distance(haversine.Coord{Lat: lat, Lon: lng}, c)
+		}
+		sort.Float64s(distances)
+
+		result, err := cs.Nearest(context.Background(), lat, lng, 10)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		if len(result) != 10 {
+			t.Fatalf("expected 10 neighbours, got %d", len(result))
+		}
+		for j, c := range result {
+			if math.Abs(c.DistanceKm-distances[j]) > 0.001 {
+				t.Fatalf("expected neighbour %d of %v,%v to be %vkm away, got %vkm", j, lat, lng, distances[j], c.DistanceKm)
+			}
+		}
+	}
+}
+
+func BenchmarkNearest(b *testing.B) {
+	cs, err := cities.NewCitySearcher(strings.NewReader(citiesCSV(randomCoords(200000))))
+	if err != nil {
+		b.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	for _, k := range []int{1, 10, 100} {
+		b.Run(fmt.Sprint(k), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := cs.Nearest(context.Background(), 51.5, -0.1, k); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
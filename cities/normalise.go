@@ -0,0 +1,84 @@
+package cities
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// letters that don't decompose into a base letter and a diacritic,
+// so need folding by hand
+var letterFolds = map[rune]string{
+	'ı': "i", // Turkish dotless i
+	'ł': "l",
+	'đ': "d",
+	'ð': "d",
+	'ø': "o",
+	'æ': "ae",
+	'œ': "oe",
+	'þ': "th",
+}
+
+// abbreviations are expanded so that "St. Albans" and "Saint Albans" match
+var abbreviations = map[string]string{
+	"st":  "saint",
+	"ste": "sainte",
+	"mt":  "mount",
+	"ft":  "fort",
+}
+
+// Normalise puts a name or query into the form that names are compared in:
+// compatibility decomposed with the diacritics stripped, case folded, with
+// punctuation and runs of whitespace collapsed to single spaces, and common
+// abbreviations spelled out. Search applies it to both sides of a comparison.
+func Normalise(s string) string {
+	folded := strings.ToLower(s)
+	if !isASCII(s) {
+		// transformers hold state, so can't be shared between goroutines
+		t := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), cases.Fold())
+		if f, _, err := transform.String(t, s); err == nil {
+			folded = f
+		}
+	}
+
+	var b strings.Builder
+	b.Grow(len(folded))
+	for _, r := range folded {
+		switch {
+		case letterFolds[r] != "":
+			b.WriteString(letterFolds[r])
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			b.WriteRune(r)
+		case r == '\'' || r == '’':
+			// "King's Lynn" is usually searched for as "kings lynn"
+		default:
+			b.WriteRune(' ')
+		}
+	}
+
+	words := strings.Fields(b.String())
+	// the last word of a query may not be finished, and expanding it would
+	// stop "st" matching "Stoke", so abbreviations only count before another word
+	for i := 0; i < len(words)-1; i++ {
+		if e, ok := abbreviations[words[i]]; ok {
+			words[i] = e
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// isASCII reports whether s can skip the (comparatively slow) unicode folding
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package cities_test
+
+import (
+	"testing"
+
+	"github.com/oskanberg/citysearch/cities"
+)
+
+func TestNormalise(t *testing.T) {
+	type test struct {
+		name     string
+		in       string
+		expected string
+	}
+
+	cases := []test{
+		{name: "lowercases", in: "LONDON", expected: "london"},
+		{name: "strips latin diacritics", in: "Zürich", expected: "zurich"},
+		{name: "strips macrons", in: "Ōsaka", expected: "osaka"},
+		{name: "strips stacked diacritics", in: "Hải Phòng", expected: "hai phong"},
+		{name: "folds letters that don't decompose", in: "Łódź", expected: "lodz"},
+		{name: "folds ligatures", in: "Færøerne", expected: "faeroerne"},
+		{name: "folds sharp s", in: "Gießen", expected: "giessen"},
+		{name: "turkish dotted capital I", in: "İstanbul", expected: "istanbul"},
+		{name: "turkish dotless i", in: "Iğdır", expected: "igdir"},
+		{name: "cyrillic", in: "МОСКВА", expected: "москва"},
+		{name: "cyrillic short i", in: "Йошкар-Ола", expected: "иошкар ола"},
+		{name: "greek final sigma", in: "ΑΘΗΝΑΣ", expected: "αθηνασ"},
+		{name: "greek tonos", in: "Αθήνα", expected: "αθηνα"},
+		{name: "full width latin", in: "ＴＯＫＹＯ", expected: "tokyo"},
+		{name: "japanese voiced marks", in: "ガ", expected: "カ"},
+		{name: "han is untouched", in: "北京", expected: "北京"},
+		{name: "hyphens become spaces", in: "Stoke-on-Trent", expected: "stoke on trent"},
+		{name: "apostrophes are dropped", in: "King's Lynn", expected: "kings lynn"},
+		{name: "curly apostrophes are dropped", in: "King’s Lynn", expected: "kings lynn"},
+		{name: "whitespace is collapsed and trimmed", in: "  New \t  York ", expected: "new york"},
+		{name: "st. is expanded", in: "St. Albans", expected: "saint albans"},
+		{name: "st is expanded", in: "st ives", expected: "saint ives"},
+		{name: "ste is expanded", in: "Ste-Foy", expected: "sainte foy"},
+		{name: "mt is expanded", in: "Mt. Pleasant", expected: "mount pleasant"},
+		{name: "ft is expanded", in: "Ft William", expected: "fort william"},
+		{name: "trailing abbreviation is kept", in: "st", expected: "st"},
+		{name: "abbreviation inside a word is kept", in: "Stockport", expected: "stockport"},
+		{name: "empty", in: "", expected: ""},
+		{name: "only punctuation", in: "-.-", expected: ""},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := cities.Normalise(tc.in); got != tc.expected {
+				t.Fatalf("expected '%s', got '%s'", tc.expected, got)
+			}
+		})
+	}
+}
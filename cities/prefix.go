@@ -0,0 +1,138 @@
+package cities
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// prefixEntry is the rest of an indexed name from the start of one of its
+// words, e.g. "saint albans" has entries "saint albans" and "albans"
+type prefixEntry struct {
+	suffix string
+	id     int32
+}
+
+// buildPrefixes fills in the sorted word-start index used for prefix
+// matching, so that every name with a word starting with the query sits
+// in one contiguous run that can be found by binary search
+func (idx *nameIndex) buildPrefixes() {
+	for i, n := range idx.names {
+		idx.prefixes = append(idx.prefixes, prefixEntry{n, int32(i)})
+		// names are normalised, so words are separated by exactly one space
+		for j := 0; j < len(n); j++ {
+			if n[j] == ' ' {
+				idx.prefixes = append(idx.prefixes, prefixEntry{n[j+1:], int32(i)})
+			}
+		}
+	}
+
+	sort.Slice(idx.prefixes, func(i, j int) bool {
+		return idx.prefixes[i].suffix < idx.prefixes[j].suffix
+	})
+}
+
+// findPrefix returns names in parts with a word starting with query
+// (which must be normalised), at their Levenshtein distance from the query,
+// leaving out those keep (if not nil) returns false for. A letter or two
+// starts a word of a good part of every name there is, so like scan it
+// stops at maxCandidates matches, taking the names of the most populous
+// cities first. Matches are ordered best first, ties broken by position in
+// the index.
+func (idx *nameIndex) findPrefix(ctx context.Context, query string, parts []*partition, keep func(id int32) bool) ([]match, error) {
+	if query == "" {
+		return nil, nil
+	}
+
+	start := sort.Search(len(idx.prefixes), func(i int) bool {
+		return idx.prefixes[i].suffix >= query
+	})
+	end := start + sort.Search(len(idx.prefixes)-start, func(i int) bool {
+		return !strings.HasPrefix(idx.prefixes[start+i].suffix, query)
+	})
+
+	var ids []int32
+	var err error
+	if end-start <= maxCandidates {
+		ids, err = idx.prefixRun(ctx, start, end, keep)
+	} else {
+		// too many to take them all, and picking out the biggest places
+		// would mean sorting them; going through the names biggest places
+		// first finds enough after about maxCandidates*len(names)/(end-start)
+		ids, err = idx.prefixesByPopulation(ctx, query, parts, keep)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the query is a subsequence of each name, so the distance is just how
+	// many more runes the name has
+	qLen := utf8.RuneCountInString(query)
+	matches := make([]match, len(ids))
+	for i, id := range ids {
+		matches[i] = match{id, utf8.RuneCountInString(idx.names[id]) - qLen}
+	}
+	sortMatches(matches)
+	return matches, nil
+}
+
+// prefixRun returns the names in prefixes[start:end] that keep (if not nil)
+// returns true for
+func (idx *nameIndex) prefixRun(ctx context.Context, start, end int, keep func(id int32) bool) ([]int32, error) {
+	seen := make(map[int32]bool)
+	var ids []int32
+	for i, e := range idx.prefixes[start:end] {
+		if err := cancelled(ctx, i); err != nil {
+			return nil, err
+		}
+		// a name can have several words starting with the query
+		if seen[e.id] {
+			continue
+		}
+		seen[e.id] = true
+		if keep == nil || keep(e.id) {
+			ids = append(ids, e.id)
+		}
+	}
+	return ids, nil
+}
+
+// prefixesByPopulation returns the first maxCandidates names in parts with
+// a word starting with query that keep (if not nil) returns true for, going
+// through the names of the most populous cities first
+func (idx *nameIndex) prefixesByPopulation(ctx context.Context, query string, parts []*partition, keep func(id int32) bool) ([]int32, error) {
+	letters := letterSet(query)
+	var ids []int32
+	for i, id := range idx.populationOrder(parts) {
+		if err := cancelled(ctx, i); err != nil {
+			return nil, err
+		}
+		if idx.letters[id]&letters != letters || !startsWord(idx.names[id], query) {
+			continue
+		}
+		if keep == nil || keep(id) {
+			ids = append(ids, id)
+			if len(ids) == maxCandidates {
+				break
+			}
+		}
+	}
+	return ids, nil
+}
+
+// startsWord is whether a word of name (which must be normalised) starts
+// with query
+func startsWord(name, query string) bool {
+	for {
+		if strings.HasPrefix(name, query) {
+			return true
+		}
+		// names are normalised, so words are separated by exactly one space
+		i := strings.IndexByte(name, ' ')
+		if i < 0 {
+			return false
+		}
+		name = name[i+1:]
+	}
+}
@@ -0,0 +1,207 @@
+package cities_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/oskanberg/citysearch/cities"
+)
+
+func TestPrefixMode(t *testing.T) {
+	csv := `geonameid,name,latitude,longitude
+2653192,Chichester,50.83673,-0.78003
+2653225,Christchurch,50.73583,-1.78129
+2646557,Hitchin,51.94924,-0.28496
+2647880,Great Chishill,52.03333,0.06667
+`
+
+	type test struct {
+		name     string
+		opts     cities.SearchOptions
+		location bool
+		expected []string
+	}
+
+	cases := []test{
+		{
+			name:     "fuzzy mode ranks short names first",
+			opts:     cities.SearchOptions{Mode: cities.ModeFuzzy},
+			expected: []string{"Hitchin", "Chichester", "Christchurch", "Great Chishill"},
+		},
+		{
+			name:     "prefix mode ranks names starting with the query first",
+			opts:     cities.SearchOptions{Mode: cities.ModePrefix},
+			expected: []string{"Chichester", "Great Chishill", "Hitchin", "Christchurch"},
+		},
+		{
+			name:     "prefix matches outrank closer places",
+			opts:     cities.SearchOptions{Mode: cities.ModePrefix},
+			location: true,
+			expected: []string{"Chichester", "Great Chishill", "Hitchin", "Christchurch"},
+		},
+		{
+			name:     "prefix matches can fill the page on their own",
+			opts:     cities.SearchOptions{Mode: cities.ModePrefix, Limit: 1},
+			expected: []string{"Chichester"},
+		},
+		{
+			name:     "other matches fill the rest of the page",
+			opts:     cities.SearchOptions{Mode: cities.ModePrefix, Limit: 2, Offset: 1},
+			expected: []string{"Great Chishill", "Hitchin"},
+		},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cs, err := cities.NewCitySearcher(strings.NewReader(csv))
+			if err != nil {
+				t.Fatalf("failed to make city searcher: %s", err)
+			}
+
+			var results []cities.CityWithScore
+			if tc.location {
+				// searching from Hitchin itself, which still can't beat the prefix matches
+				results, err = cs.SearchWithLocation(context.Background(), "Chi", 51.94924, -0.28496, tc.opts)
+			} else {
+				results, err = cs.Search(context.Background(), "Chi", tc.opts)
+			}
+			if err != nil {
+				t.Fatalf("failed to search: %s", err)
+			}
+
+			got := make([]string, len(results))
+			for i, r := range results {
+				got[i] = r.Name
+				// scores should agree with the ranking, not just the order
+				if i > 0 && r.Score > results[i-1].Score {
+					t.Fatalf("expected scores in descending order, got %v", results)
+				}
+			}
+			if !reflect.DeepEqual(tc.expected, got) {
+				t.Fatalf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestPrefixModeMatchesWordStarts(t *testing.T) {
+	csv := `geonameid,name,alternatenames,latitude,longitude
+2638867,St Albans,"Verulamium",51.75,-0.33333
+2641170,Northampton,"",52.25,-0.88333
+`
+
+	cs, err := cities.NewCitySearcher(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	for query, expected := range map[string]string{
+		"alb":    "St Albans",
+		"saint":  "St Albans",
+		"st alb": "St Albans",
+		"veru":   "Verulamium",
+		"north":  "Northampton",
+	} {
+		results, err := cs.Search(context.Background(), query, cities.SearchOptions{Mode: cities.ModePrefix})
+		if err != nil {
+			t.Fatalf("failed to search: %s", err)
+		}
+		if len(results) != 1 || results[0].MatchedName != expected {
+			t.Fatalf("expected %s to match %s, got %v", query, expected, results)
+		}
+		if results[0].Score < 0.5 {
+			t.Fatalf("expected %s to be a prefix match, but it scored %f", query, results[0].Score)
+		}
+	}
+
+	// "ampton" is in Northampton, but not at the start of a word
+	results, err := cs.Search(context.Background(), "ampton", cities.SearchOptions{Mode: cities.ModePrefix})
+	if err != nil {
+		t.Fatalf("failed to search: %s", err)
+	}
+	if len(results) != 1 || results[0].Score >= 0.5 {
+		t.Fatalf("expected a single fuzzy match, got %v", results)
+	}
+}
+
+func TestPrefixModeManyMatches(t *testing.T) {
+	type test struct {
+		name string
+		// villages is how many small places start with the query, which
+		// decides how findPrefix goes about finding them
+		villages int
+		opts     cities.SearchOptions
+		expected []string
+	}
+
+	cases := []test{
+		{
+			name:     "biggest places kept when there are too many to take",
+			villages: 3000,
+			opts:     cities.SearchOptions{Mode: cities.ModePrefix},
+			expected: []string{"Springfield", "Sandown"},
+		},
+		{
+			name:     "every place taken when there aren't",
+			villages: 500,
+			opts:     cities.SearchOptions{Mode: cities.ModePrefix},
+			expected: []string{"Springfield", "Sandown"},
+		},
+		{
+			name:     "other countries don't crowd out the one asked for",
+			villages: 3000,
+			opts:     cities.SearchOptions{Mode: cities.ModePrefix, Countries: []string{"GB"}},
+			expected: []string{"Sandown"},
+		},
+		{
+			name:     "nor when there are few to take",
+			villages: 500,
+			opts:     cities.SearchOptions{Mode: cities.ModePrefix, Countries: []string{"GB"}},
+			expected: []string{"Sandown"},
+		},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			// the villages are all shorter (so closer) names than the places wanted
+			var sb strings.Builder
+			sb.WriteString("geonameid,name,latitude,longitude,country code,population\n")
+			for i := 0; i < tc.villages; i++ {
+				fmt.Fprintf(&sb, "%d,Sv %d,0,0,US,10\n", i, i)
+			}
+			sb.WriteString("4951788,Springfield,42.10148,-72.58981,US,1000000\n")
+			sb.WriteString("2638419,Sandown,50.65058,-1.15473,GB,11000\n")
+			cs, err := cities.NewCitySearcher(strings.NewReader(sb.String()))
+			if err != nil {
+				t.Fatalf("failed to make city searcher: %s", err)
+			}
+
+			results, err := cs.Search(context.Background(), "s", tc.opts)
+			if err != nil {
+				t.Fatalf("failed to search: %s", err)
+			}
+
+			prefixes := make(map[string]bool)
+			for _, r := range results {
+				if r.Score >= 0.5 {
+					prefixes[r.Name] = true
+				}
+			}
+			if len(prefixes) > cities.MaxResults {
+				t.Fatalf("expected at most %d prefix matches, got %d", cities.MaxResults, len(prefixes))
+			}
+			for _, name := range tc.expected {
+				if !prefixes[name] {
+					t.Fatalf("expected %s among the prefix matches, got %v", name, results)
+				}
+			}
+		})
+	}
+}
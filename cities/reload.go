@@ -0,0 +1,202 @@
+package cities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNotReady is returned by a Reloader's searches until its first load
+// has succeeded
+var ErrNotReady = errors.New("cities database not loaded yet")
+
+// Loader builds a fresh CitySearcher, e.g. by reading the cities csv again
+type Loader func() (*CitySearcher, error)
+
+// FileLoader returns a Loader that reads the csv at path, configuring the
+// searcher it builds with scorer and filters
+func FileLoader(path string, scorer Scorer, filters ...FilterFunc) Loader {
+	return func() (*CitySearcher, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open cities database: %w", err)
+		}
+		defer f.Close()
+
+		cs, err := NewCitySearcher(f, filters...)
+		if err != nil {
+			return nil, err
+		}
+		cs.SetScorer(scorer)
+		return cs, nil
+	}
+}
+
+// Reloader is a CitySearcher that can be rebuilt while it is serving.
+// A reload builds a whole new searcher in the background and swaps it in
+// once it is ready, so searches already running finish against the old one,
+// and if the reload fails the old one carries on serving.
+type Reloader struct {
+	load Loader
+	// current holds the *CitySearcher that searches go to
+	current atomic.Value
+	// mu stops reloads running over each other
+	mu sync.Mutex
+}
+
+// NewReloader creates a Reloader, loading its first searcher with load
+func NewReloader(load Loader) (*Reloader, error) {
+	r := &Reloader{load: load}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewPendingReloader creates a Reloader with nothing loaded until Reload is
+// first called, so that a big database can be loaded in the background
+// while the Reloader is asked whether it is Ready. Until a load succeeds,
+// searches fail with ErrNotReady.
+func NewPendingReloader(load Loader) *Reloader {
+	return &Reloader{load: load}
+}
+
+// Reload builds a new searcher and swaps it in. On error, the previous
+// searcher is kept.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cs, err := r.load()
+	if err != nil {
+		return fmt.Errorf("failed to reload cities: %w", err)
+	}
+	r.current.Store(cs)
+	return nil
+}
+
+// Ready is whether a searcher has been loaded, so searches can be served
+func (r *Reloader) Ready() bool {
+	return r.Searcher() != nil
+}
+
+// Searcher returns the searcher currently serving, or nil if none has
+// been loaded yet
+func (r *Reloader) Searcher() *CitySearcher {
+	cs, _ := r.current.Load().(*CitySearcher)
+	return cs
+}
+
+// searcher is Searcher, but with an error if there isn't one yet
+func (r *Reloader) searcher() (*CitySearcher, error) {
+	cs := r.Searcher()
+	if cs == nil {
+		return nil, ErrNotReady
+	}
+	return cs, nil
+}
+
+// Stats is CitySearcher.Stats for the current searcher, or zero if none
+// has been loaded yet
+func (r *Reloader) Stats() Stats {
+	cs := r.Searcher()
+	if cs == nil {
+		return Stats{}
+	}
+	return cs.Stats()
+}
+
+// Search is CitySearcher.Search against the current searcher
+func (r *Reloader) Search(ctx context.Context, query string, opts SearchOptions) ([]CityWithScore, error) {
+	cs, err := r.searcher()
+	if err != nil {
+		return nil, err
+	}
+	return cs.Search(ctx, query, opts)
+}
+
+// SearchWithLocation is CitySearcher.SearchWithLocation against the current searcher
+func (r *Reloader) SearchWithLocation(ctx context.Context, query string, lat, lng float64, opts SearchOptions) ([]CityWithScore, error) {
+	cs, err := r.searcher()
+	if err != nil {
+		return nil, err
+	}
+	return cs.SearchWithLocation(ctx, query, lat, lng, opts)
+}
+
+// SearchWithin is CitySearcher.SearchWithin against the current searcher
+func (r *Reloader) SearchWithin(ctx context.Context, query string, area Area, opts SearchOptions) ([]CityWithScore, error) {
+	cs, err := r.searcher()
+	if err != nil {
+		return nil, err
+	}
+	return cs.SearchWithin(ctx, query, area, opts)
+}
+
+// SearchWithLocationWithin is CitySearcher.SearchWithLocationWithin against the current searcher
+func (r *Reloader) SearchWithLocationWithin(ctx context.Context, query string, lat, lng float64, area Area, opts SearchOptions) ([]CityWithScore, error) {
+	cs, err := r.searcher()
+	if err != nil {
+		return nil, err
+	}
+	return cs.SearchWithLocationWithin(ctx, query, lat, lng, area, opts)
+}
+
+// City is CitySearcher.City against the current searcher
+func (r *Reloader) City(id string) (City, error) {
+	cs, err := r.searcher()
+	if err != nil {
+		return City{}, err
+	}
+	return cs.City(id)
+}
+
+// Nearest is CitySearcher.Nearest against the current searcher
+func (r *Reloader) Nearest(ctx context.Context, lat, lng float64, k int) ([]CityWithDistance, error) {
+	cs, err := r.searcher()
+	if err != nil {
+		return nil, err
+	}
+	return cs.Nearest(ctx, lat, lng, k)
+}
+
+// Watch checks the file at path every interval, and reloads when its size or
+// modification time changes from what it is when Watch is called, until ctx
+// is done. It returns straight away, checking in the background. The result
+// of every reload it triggers is passed to done, which may be nil.
+func (r *Reloader) Watch(ctx context.Context, path string, interval time.Duration, done func(error)) {
+	// polling rather than using inotify and friends: the file is usually
+	// replaced wholesale, by a deploy or a rename, which those report patchily
+	last, _ := os.Stat(path)
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				// probably mid-replace; try again next tick
+				continue
+			}
+			if last != nil && info.Size() == last.Size() && info.ModTime().Equal(last.ModTime()) {
+				continue
+			}
+			last = info
+
+			err = r.Reload()
+			if done != nil {
+				done(err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,164 @@
+package cities_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oskanberg/citysearch/cities"
+)
+
+func writeCities(t *testing.T, path, csv string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(csv), 0o644); err != nil {
+		t.Fatalf("failed to write cities: %s", err)
+	}
+}
+
+func firstName(t *testing.T, r *cities.Reloader, query string) string {
+	t.Helper()
+	result, err := r.Search(context.Background(), query, cities.SearchOptions{})
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(result) == 0 {
+		return ""
+	}
+	return result[0].Name
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "cities.csv")
+	writeCities(t, path, "geonameid,name,latitude,longitude\n1,London,51.5,-0.1\n")
+
+	r, err := cities.NewReloader(cities.FileLoader(path, cities.DefaultScorer))
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if got := firstName(t, r, "lon"); got != "London" {
+		t.Fatalf("expected London, got %q", got)
+	}
+
+	// an in-flight search keeps the searcher it started with
+	old := r.Searcher()
+	if stats := r.Stats(); stats.Cities != 1 || stats.Names != 1 || stats.BuiltAt.IsZero() {
+		t.Fatalf("expected stats for one city, got %+v", stats)
+	}
+
+	writeCities(t, path, "geonameid,name,latitude,longitude\n2,Londonderry,55,-7.3\n")
+	if err := r.Reload(); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if got := firstName(t, r, "lon"); got != "Londonderry" {
+		t.Fatalf("expected Londonderry after reload, got %q", got)
+	}
+	if r.Stats().BuiltAt.Before(old.Stats().BuiltAt) {
+		t.Fatalf("expected the reloaded searcher to be newer, got %+v", r.Stats())
+	}
+	if res, _ := old.Search(context.Background(), "lon", cities.SearchOptions{}); len(res) != 1 || res[0].Name != "London" {
+		t.Fatalf("expected the old searcher to be untouched, got %+v", res)
+	}
+
+	// a broken file is rejected, and the last good data kept
+	writeCities(t, path, "geonameid,name\n")
+	if err := r.Reload(); err == nil {
+		t.Fatalf("expected an error reloading an empty database")
+	}
+	if got := firstName(t, r, "lon"); got != "Londonderry" {
+		t.Fatalf("expected Londonderry to survive a failed reload, got %q", got)
+	}
+}
+
+func TestNewReloaderFails(t *testing.T) {
+	t.Parallel()
+
+	_, err := cities.NewReloader(cities.FileLoader(filepath.Join(t.TempDir(), "missing.csv"), cities.DefaultScorer))
+	if err == nil {
+		t.Fatalf("expected an error loading a missing file")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "cities.csv")
+	writeCities(t, path, "geonameid,name,latitude,longitude\n1,London,51.5,-0.1\n")
+
+	r, err := cities.NewReloader(cities.FileLoader(path, cities.DefaultScorer))
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloaded := make(chan error, 1)
+	r.Watch(ctx, path, 5*time.Millisecond, func(err error) {
+		select {
+		case reloaded <- err:
+		default:
+		}
+	})
+
+	writeCities(t, path, "geonameid,name,latitude,longitude\n2,Londonderry,55,-7.3\n")
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the change to be picked up")
+	}
+	if got := firstName(t, r, "lon"); got != "Londonderry" {
+		t.Fatalf("expected Londonderry after reload, got %q", got)
+	}
+}
+
+func TestPendingReloader(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "cities.csv")
+	csv := "geonameid,name,latitude,longitude\n1,London,51.5,-0.1\n"
+	writeCities(t, path, csv)
+
+	r := cities.NewPendingReloader(cities.FileLoader(path, cities.DefaultScorer))
+
+	if r.Ready() {
+		t.Fatalf("expected not to be ready before loading")
+	}
+	if _, err := r.Search(context.Background(), "lon", cities.SearchOptions{}); !errors.Is(err, cities.ErrNotReady) {
+		t.Fatalf("expected %v, got %v", cities.ErrNotReady, err)
+	}
+	if _, err := r.Nearest(context.Background(), 51.5, -0.1, 1); !errors.Is(err, cities.ErrNotReady) {
+		t.Fatalf("expected %v, got %v", cities.ErrNotReady, err)
+	}
+	if _, err := r.City("1"); !errors.Is(err, cities.ErrNotReady) {
+		t.Fatalf("expected %v, got %v", cities.ErrNotReady, err)
+	}
+	if stats := r.Stats(); stats != (cities.Stats{}) {
+		t.Fatalf("expected no stats, got %+v", stats)
+	}
+
+	if err := r.Reload(); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if !r.Ready() {
+		t.Fatalf("expected to be ready once loaded")
+	}
+	if got := firstName(t, r, "lon"); got != "London" {
+		t.Fatalf("expected London, got %q", got)
+	}
+	if c, err := r.City("1"); err != nil || c.Name != "London" {
+		t.Fatalf("expected London, got %+v (%v)", c, err)
+	}
+	sum := sha256.Sum256([]byte(csv))
+	if stats := r.Stats(); stats.Checksum != hex.EncodeToString(sum[:]) {
+		t.Fatalf("expected checksum %x, got %s", sum, stats.Checksum)
+	}
+}
@@ -0,0 +1,132 @@
+package cities
+
+import "math"
+
+// Features are the signals a Scorer has to go on for a single result
+type Features struct {
+	// Text is how closely the name matched the query, from 0 to 1 (exact).
+	// It is inversely proportional to the Levenshtein distance.
+	Text float64
+	// HasLocation is whether the search was from a location at all;
+	// if it wasn't, the distances are 0 and should be ignored
+	HasLocation bool
+	// DistanceKm is how far the city is from the location searched from
+	DistanceKm float64
+	// NearestKm is how far the closest matching city is from the location
+	NearestKm float64
+	// Population is the city's population on a log scale between the
+	// smallest (0) and largest (1) city loaded
+	Population float64
+	// City is the city being scored, for scorers wanting anything else
+	City *City
+}
+
+// Scorer turns the features of a result into its final score. Results are
+// ranked highest score first, so scores only need to be consistent with one
+// another, though the scorers here all keep them between 0 and 1.
+type Scorer interface {
+	Score(f Features) float64
+}
+
+// Weights sets how much each signal counts towards a result's score. The
+// scorers here take the weighted mean of the signals, each of which is
+// between 0 and 1, so only the ratios between the weights matter.
+type Weights struct {
+	// Text weights how closely the name matched the query
+	Text float64
+	// Distance weights how close the city is to the location searched from.
+	// It only counts when there is a location.
+	Distance float64
+	// Population weights how big the city is. This is what lets "Lon"
+	// suggest London ahead of smaller places with a closer name.
+	Population float64
+}
+
+// DefaultWeights weigh text and distance equally, and ignore population,
+// so that DefaultScorer scores as searches always have. The service weighs
+// population too, by its --population-weight flag.
+var DefaultWeights = Weights{Text: 1, Distance: 1}
+
+// DefaultScorer is what a CitySearcher uses unless told otherwise: the mean
+// of the text and (when there is a location) distance scores
+var DefaultScorer Scorer = LinearScorer{DefaultWeights}
+
+// mean is the weighted mean of the signals. Distance is left out
+// (rather than counted as 0) when there was no location.
+func (w Weights) mean(f Features, distance float64) float64 {
+	total := w.Text*f.Text + w.Population*f.Population
+	sum := w.Text + w.Population
+	if f.HasLocation {
+		total += w.Distance * distance
+		sum += w.Distance
+	}
+
+	// nothing weighted, so there's nothing sensible to rank on but the text
+	if sum <= 0 {
+		return f.Text
+	}
+	return total / sum
+}
+
+// LinearScorer takes the weighted mean of the signals, with distance scored
+// relative to the nearest match: it scores 1, and the rest score in inverse
+// proportion to how much further away they are. That makes distance count
+// for a lot between nearby places, and very little between far away ones.
+type LinearScorer struct {
+	Weights Weights
+}
+
+// Score implements Scorer
+func (s LinearScorer) Score(f Features) float64 {
+	// 0 is best distance, add 1 to avoid /0
+	distance := math.Max(1, f.NearestKm) / (f.DistanceKm + 1)
+	return s.Weights.mean(f, distance)
+}
+
+// LogDecayScorer takes the weighted mean of the signals, with distance
+// decaying logarithmically: a city ScaleKm away scores 1/(1+ln 2), one
+// 10*ScaleKm away scores 1/(1+ln 11), and so on. Unlike LinearScorer, it
+// doesn't depend on the other matches, and far away places keep telling
+// apart by distance rather than all scoring close to 0.
+type LogDecayScorer struct {
+	Weights Weights
+	// ScaleKm is the distance at which the score starts falling off
+	ScaleKm float64
+}
+
+// Score implements Scorer
+func (s LogDecayScorer) Score(f Features) float64 {
+	scale := s.ScaleKm
+	if scale <= 0 {
+		scale = 1
+	}
+	distance := 1 / (1 + math.Log1p(f.DistanceKm/scale))
+	return s.Weights.mean(f, distance)
+}
+
+// populationScale maps populations onto a 0-1 score, on a log scale so
+// that the handful of megacities don't squash everything else to 0
+type populationScale struct {
+	low, high float64
+}
+
+func newPopulationScale(cities []City) populationScale {
+	if len(cities) == 0 {
+		return populationScale{}
+	}
+
+	s := populationScale{math.Inf(1), math.Inf(-1)}
+	for _, c := range cities {
+		p := math.Log1p(float64(c.Population))
+		s.low = math.Min(s.low, p)
+		s.high = math.Max(s.high, p)
+	}
+	return s
+}
+
+func (s populationScale) score(population int64) float64 {
+	if s.high <= s.low {
+		return 0
+	}
+	return (math.Log1p(float64(population)) - s.low) / (s.high - s.low)
+}
@@ -0,0 +1,186 @@
+package cities_test
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/oskanberg/citysearch/cities"
+)
+
+func TestScorers(t *testing.T) {
+	type test struct {
+		name     string
+		scorer   cities.Scorer
+		features cities.Features
+		expected float64
+	}
+
+	cases := []test{
+		{
+			name:     "default without location is the text score",
+			scorer:   cities.DefaultScorer,
+			features: cities.Features{Text: 0.5, Population: 1},
+			expected: 0.5,
+		},
+		{
+			name:     "default with location is the mean of text and relative distance",
+			scorer:   cities.DefaultScorer,
+			features: cities.Features{Text: 0.5, HasLocation: true, DistanceKm: 19, NearestKm: 10, Population: 1},
+			expected: (0.5 + 10.0/20) / 2,
+		},
+		{
+			name:     "nearest match gets full distance score",
+			scorer:   cities.DefaultScorer,
+			features: cities.Features{Text: 0.5, HasLocation: true, DistanceKm: 0, NearestKm: 0},
+			expected: (0.5 + 1) / 2,
+		},
+		{
+			name:     "linear weights each signal",
+			scorer:   cities.LinearScorer{Weights: cities.Weights{Text: 2, Distance: 1, Population: 1}},
+			features: cities.Features{Text: 0.5, HasLocation: true, DistanceKm: 19, NearestKm: 10, Population: 0.25},
+			expected: (2*0.5 + 10.0/20 + 0.25) / 4,
+		},
+		{
+			name:     "linear leaves out distance without location",
+			scorer:   cities.LinearScorer{Weights: cities.Weights{Text: 2, Distance: 1, Population: 1}},
+			features: cities.Features{Text: 0.5, Population: 0.25},
+			expected: (2*0.5 + 0.25) / 3,
+		},
+		{
+			name:     "log decay at zero distance",
+			scorer:   cities.LogDecayScorer{Weights: cities.Weights{Distance: 1}, ScaleKm: 10},
+			features: cities.Features{HasLocation: true, DistanceKm: 0, NearestKm: 5},
+			expected: 1,
+		},
+		{
+			name:     "log decay at the scale distance",
+			scorer:   cities.LogDecayScorer{Weights: cities.Weights{Distance: 1}, ScaleKm: 10},
+			features: cities.Features{HasLocation: true, DistanceKm: 10, NearestKm: 5},
+			expected: 1 / (1 + math.Log(2)),
+		},
+		{
+			name:     "log decay ignores the nearest match",
+			scorer:   cities.LogDecayScorer{Weights: cities.Weights{Distance: 1}, ScaleKm: 10},
+			features: cities.Features{HasLocation: true, DistanceKm: 100, NearestKm: 100},
+			expected: 1 / (1 + math.Log(11)),
+		},
+		{
+			name:     "log decay mixes with the other signals",
+			scorer:   cities.LogDecayScorer{Weights: cities.Weights{Text: 1, Distance: 1, Population: 2}, ScaleKm: 10},
+			features: cities.Features{Text: 0.5, HasLocation: true, DistanceKm: 10, Population: 0.25},
+			expected: (0.5 + 1/(1+math.Log(2)) + 2*0.25) / 4,
+		},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tc.scorer.Score(tc.features); math.Abs(got-tc.expected) > 1e-9 {
+				t.Fatalf("expected score %f, got %f", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestPopulationWeighting(t *testing.T) {
+	csv := `geonameid,name,latitude,longitude,population
+2643743,London,51.50853,-0.12574,8961989
+2643339,Lone,53.0,-1.0,15000
+2643123,Longa,55.0,-4.0,15500
+`
+
+	type test struct {
+		name     string
+		scorer   cities.Scorer
+		location bool
+		check    func(t *testing.T, c []cities.CityWithScore)
+	}
+
+	cases := []test{
+		{
+			name:   "default scorer ignores population",
+			scorer: cities.DefaultScorer,
+			check: func(t *testing.T, c []cities.CityWithScore) {
+				if c[0].Name != "Lone" {
+					t.Fatalf("expected the closest name, Lone, first, but was %s", c[0].Name)
+				}
+				if c[0].Score != 0.5 {
+					t.Fatalf("expected score to be text alone (0.5), but got %f", c[0].Score)
+				}
+			},
+		},
+		{
+			name:   "population weight boosts large cities",
+			scorer: cities.LinearScorer{Weights: cities.Weights{Text: 1, Distance: 1, Population: 0.5}},
+			check: func(t *testing.T, c []cities.CityWithScore) {
+				if c[0].Name != "London" {
+					t.Fatalf("expected London first, but was %s", c[0].Name)
+				}
+				// text 1/4, population 1: (0.25 + 0.5) / 1.5
+				if math.Abs(c[0].Score-0.5) > 1e-9 {
+					t.Fatalf("expected London to score 0.5, but got %f", c[0].Score)
+				}
+			},
+		},
+		{
+			name:   "only population",
+			scorer: cities.LinearScorer{Weights: cities.Weights{Population: 1}},
+			check: func(t *testing.T, c []cities.CityWithScore) {
+				if c[0].Name != "London" || c[1].Name != "Longa" || c[2].Name != "Lone" {
+					t.Fatalf("expected results ordered by population, but got %v", c)
+				}
+				if c[2].Score != 0 {
+					t.Fatalf("expected the smallest city to score 0, but got %f", c[2].Score)
+				}
+			},
+		},
+		{
+			name:   "no weights falls back to text",
+			scorer: cities.LinearScorer{Weights: cities.Weights{}},
+			check: func(t *testing.T, c []cities.CityWithScore) {
+				if c[0].Name != "Lone" {
+					t.Fatalf("expected the closest name, Lone, first, but was %s", c[0].Name)
+				}
+			},
+		},
+		{
+			name:     "population combines with distance",
+			scorer:   cities.LinearScorer{Weights: cities.Weights{Text: 1, Distance: 1, Population: 2}},
+			location: true,
+			check: func(t *testing.T, c []cities.CityWithScore) {
+				// searching from Lone: with population weighted heavily, London's
+				// size outweighs Lone's proximity, but Longa is small and far away
+				if c[0].Name != "London" || c[1].Name != "Lone" || c[2].Name != "Longa" {
+					t.Fatalf("expected London, Lone, Longa, but got %v", c)
+				}
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cs, err := cities.NewCitySearcher(strings.NewReader(csv))
+			if err != nil {
+				t.Fatalf("failed to make city searcher: %s", err)
+			}
+			cs.SetScorer(tc.scorer)
+
+			var results []cities.CityWithScore
+			if tc.location {
+				results, err = cs.SearchWithLocation(context.Background(), "Lon", 53.0, -1.0, cities.SearchOptions{})
+			} else {
+				results, err = cs.Search(context.Background(), "Lon", cities.SearchOptions{})
+			}
+			if err != nil {
+				t.Fatalf("failed to search: %s", err)
+			}
+
+			tc.check(t, results)
+		})
+	}
+}
@@ -2,35 +2,236 @@ package cities
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/jszwec/csvutil"
-	"github.com/lithammer/fuzzysearch/fuzzy"
-	"github.com/umahmood/haversine"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// ErrCityNotFound is returned when asking for a city that isn't there
+var ErrCityNotFound = errors.New("city not found")
+
 // stutters slightly, but naming is hard
+// The fields mirror the columns of the GeoNames dump, see
+// https://download.geonames.org/export/dump/readme.txt
 type City struct {
-	GeoNameID   string  `csv:"geonameid"`
-	Name        string  `csv:"name"`
-	Lat         float64 `csv:"latitude"`
-	Lng         float64 `csv:"longitude"`
-	CountryCode string  `csv:"country code"`
+	GeoNameID      string  `csv:"geonameid"`
+	Name           string  `csv:"name"`
+	ASCIIName      string  `csv:"asciiname"`
+	AlternateNames Names   `csv:"alternatenames"`
+	Lat            float64 `csv:"latitude"`
+	Lng            float64 `csv:"longitude"`
+	FeatureClass   string  `csv:"feature class"`
+	FeatureCode    string  `csv:"feature code"`
+	CountryCode    string  `csv:"country code"`
+	CC2            string  `csv:"cc2"`
+	Admin1Code     string  `csv:"admin1 code"`
+	Admin2Code     string  `csv:"admin2 code"`
+	Admin3Code     string  `csv:"admin3 code"`
+	Admin4Code     string  `csv:"admin4 code"`
+	// the numeric columns are often blank, which decodes as 0
+	Population       int64  `csv:"population,omitempty"`
+	Elevation        int    `csv:"elevation,omitempty"`
+	DEM              int    `csv:"dem,omitempty"`
+	Timezone         string `csv:"timezone"`
+	ModificationDate string `csv:"modification date"`
+}
+
+// Variants lists every name the city is known by, starting with
+// its main name and followed by its ASCII and alternate names
+func (c City) Variants() []string {
+	v := make([]string, 0, 2+len(c.AlternateNames))
+	v = append(v, c.Name, c.ASCIIName)
+	return append(v, c.AlternateNames...)
+}
+
+// variant is c.Variants()[i], without making the slice to get it from
+func (c *City) variant(i int32) string {
+	switch i {
+	case 0:
+		return c.Name
+	case 1:
+		return c.ASCIIName
+	default:
+		return c.AlternateNames[i-2]
+	}
+}
+
+// Names is a list of names, stored in the csv as a single comma separated column
+type Names []string
+
+// UnmarshalCSV implements csvutil.Unmarshaler
+func (n *Names) UnmarshalCSV(b []byte) error {
+	if len(b) == 0 {
+		*n = nil
+		return nil
+	}
+	*n = strings.Split(string(b), ",")
+	return nil
 }
 
 type CityWithScore struct {
 	City
-	Score float64
+	// MatchedName is the name (out of the city's Variants) that matched the query
+	MatchedName string
+	Score       float64
+
+	// prefix is whether a word of MatchedName starts with the query
+	prefix bool
+	// city is the city's position in the searcher
+	city int32
 }
 
 type CitySearcher struct {
-	cities    []City
-	cityNames []string
+	cities []City
+	index  *nameIndex
+	// spatial is for finding the cities nearest a point
+	spatial *kdTree
+	// positions are the cities' positions on the unit sphere, for cheap distances
+	positions []vec3
+	// cityNames[i] is the position in index of city i's first name; its
+	// names run up to cityNames[i+1]
+	cityNames  []int32
+	population populationScale
+	scorer     Scorer
+	// byID finds cities by their GeoNameID
+	byID map[string]int32
+	// builtAt is when the searcher was made
+	builtAt time.Time
+	// checksum is the hex SHA-256 of the csv the searcher was made from
+	checksum string
+}
+
+// Stats describes what a CitySearcher holds
+type Stats struct {
+	// Cities is how many cities can be found
+	Cities int
+	// Names is how many distinct names of theirs are indexed
+	Names int
+	// BuiltAt is when the searcher was made, e.g. when the data was last (re)loaded
+	BuiltAt time.Time
+	// Checksum is the hex SHA-256 of the csv the cities were read from, to
+	// tell which version of the data is being served
+	Checksum string
+}
+
+// Stats describes what the searcher holds
+func (cs *CitySearcher) Stats() Stats {
+	return Stats{len(cs.cities), len(cs.index.names), cs.builtAt, cs.checksum}
+}
+
+// Mode picks how queries are matched against names
+type Mode int
+
+const (
+	// ModeFuzzy matches names containing the query's characters in order
+	ModeFuzzy Mode = iota
+	// ModePrefix also matches fuzzily, but ranks names with a word starting
+	// with the query above all the others, whatever their other signals.
+	// Their scores are put between 0.5 and 1, and the others' between 0
+	// and 0.5, to keep them apart.
+	ModePrefix
+	// ModeTypo also matches fuzzily, but adds names a few typos (including
+	// transposed letters) away from the query, such as "Lodnon" for London.
+	// Typo matches are scored on the edit distance, like any other match.
+	ModeTypo
+)
+
+// String is the mode's name, as the API takes it
+func (m Mode) String() string {
+	switch m {
+	case ModePrefix:
+		return "prefix"
+	case ModeTypo:
+		return "typo"
+	default:
+		return "fuzzy"
+	}
+}
+
+// MaxResults is how many results a search can be counted on to find. Only
+// so many names are matched against a query, so paging past them would
+// find little or nothing.
+const MaxResults = maxCandidates
+
+// SearchOptions controls how a search matches and which page of results it returns
+type SearchOptions struct {
+	// Limit is the most results to return; 0 means no limit
+	Limit int
+	// Offset is how many of the best results to skip before returning any
+	Offset int
+	// Mode is how the query is matched; ModeFuzzy by default
+	Mode Mode
+	// Countries limits results to cities with these country codes; all
+	// countries if empty
+	Countries []string
+	// Admin1 limits results to cities with these admin1 (e.g. state or home
+	// nation) codes; all if empty. The codes are only unique within a
+	// country, so this is best used alongside Countries.
+	Admin1 []string
+	// Filter, if set, limits results to cities it returns true for
+	Filter FilterFunc
+}
+
+// cancelCheckEvery is how many times round its loops a search goes between
+// checks that its context isn't done; checking every time would be wasteful
+const cancelCheckEvery = 1024
+
+// cancelled returns ctx's error on every cancelCheckEvery'th iteration i
+// of a loop, so that long searches stop soon after they're cancelled
+func cancelled(ctx context.Context, i int) error {
+	if i%cancelCheckEvery != 0 {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// inCountries is whether c is in one of the countries opts is limited to
+func (opts SearchOptions) inCountries(c *City) bool {
+	if len(opts.Countries) == 0 {
+		return true
+	}
+	for _, cc := range opts.Countries {
+		if strings.EqualFold(cc, c.CountryCode) {
+			return true
+		}
+	}
+	return false
+}
+
+// inAdmin1 is whether c is in one of the admin1 regions opts is limited to
+func (opts SearchOptions) inAdmin1(c *City) bool {
+	if len(opts.Admin1) == 0 {
+		return true
+	}
+	for _, a := range opts.Admin1 {
+		if strings.EqualFold(a, c.Admin1Code) {
+			return true
+		}
+	}
+	return false
+}
+
+// page cuts results (which must already be sorted) down to the page opts asks for
+func page(results []CityWithScore, opts SearchOptions) []CityWithScore {
+	if opts.Offset >= len(results) {
+		return []CityWithScore{}
+	}
+	results = results[opts.Offset:]
+	if opts.Limit > 0 && opts.Limit < len(results) {
+		results = results[:opts.Limit]
+	}
+	return results
 }
 
 // Filter is a type used to filter the database of cities
@@ -63,7 +264,9 @@ func Filter(cities []City, filters ...FilterFunc) []City {
 // NewCitySearcher creates a new CitySearcher with the given csv file reader
 // and optional city filters
 func NewCitySearcher(f io.Reader, filters ...FilterFunc) (*CitySearcher, error) {
-	d, err := csvutil.NewDecoder(csv.NewReader(f))
+	// decoding reads everything, so the checksum comes along for free
+	sum := sha256.New()
+	d, err := csvutil.NewDecoder(csv.NewReader(io.TeeReader(f, sum)))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create csv decoder: %w", err)
 	}
@@ -78,50 +281,334 @@ func NewCitySearcher(f io.Reader, filters ...FilterFunc) (*CitySearcher, error)
 	if len(cities) == 0 {
 		return nil, fmt.Errorf("no cities remained after filtering")
 	}
+	// the index wants the biggest places first, so that it can go through
+	// them first without looking up their populations
+	sort.SliceStable(cities, func(i, j int) bool {
+		return cities[i].Population > cities[j].Population
+	})
 
-	// keep a copy of the names, making sure they are in the same order
-	cityNames := make([]string, len(cities))
+	index := newNameIndex(cities)
+	cs := &CitySearcher{
+		cities:     cities,
+		index:      index,
+		spatial:    newKDTree(cities),
+		positions:  make([]vec3, len(cities)),
+		cityNames:  make([]int32, len(cities)+1),
+		population: newPopulationScale(cities),
+		byID:       make(map[string]int32, len(cities)),
+		scorer:     DefaultScorer,
+		builtAt:    time.Now(),
+		checksum:   hex.EncodeToString(sum.Sum(nil)),
+	}
 	for i, c := range cities {
-		cityNames[i] = strings.ToLower(c.Name)
+		cs.positions[i] = toVec3(c.Lat, c.Lng)
+		// IDs should be unique, but if not, the first has it
+		if _, ok := cs.byID[c.GeoNameID]; !ok {
+			cs.byID[c.GeoNameID] = int32(i)
+		}
+	}
+	// names are indexed city by city, so each city's are in one run
+	for _, ref := range index.refs {
+		cs.cityNames[ref.city+1]++
+	}
+	for i := range cities {
+		cs.cityNames[i+1] += cs.cityNames[i]
+	}
+	return cs, nil
+}
+
+// SetScorer changes how results are ranked from DefaultScorer. It is not
+// safe to call while searches are running, so should be done before serving.
+func (cs *CitySearcher) SetScorer(s Scorer) {
+	cs.scorer = s
+}
+
+// Search gets scored result suggestions for query, best first. Scores come from the searcher's Scorer
+func (cs *CitySearcher) Search(ctx context.Context, query string, opts SearchOptions) ([]CityWithScore, error) {
+	return cs.SearchWithin(ctx, query, nil, opts)
+}
+
+// SearchWithin is Search, only returning cities inside area (or anywhere if area is nil)
+func (cs *CitySearcher) SearchWithin(ctx context.Context, query string, area Area, opts SearchOptions) (_ []CityWithScore, err error) {
+	ctx, span := startSearchSpan(ctx, "CitySearcher.Search", query, area, opts)
+	defer func() { endSpan(span, err) }()
+
+	result, err := cs.searchTraced(ctx, query, area, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	_, scoring := startSpan(ctx, "score")
+	for i := range result {
+		if err := cancelled(ctx, i); err != nil {
+			endSpan(scoring, err)
+			return nil, err
+		}
+		cs.score(&result[i], Features{}, opts.Mode)
+	}
+	scoring.End()
+
+	return cs.rankTraced(ctx, result, opts), nil
+}
+
+// searchTraced is search, in a span of its own called candidates
+func (cs *CitySearcher) searchTraced(ctx context.Context, query string, area Area, near *vec3, opts SearchOptions) (_ []CityWithScore, err error) {
+	ctx, span := startSpan(ctx, "candidates")
+	defer func() { endSpan(span, err) }()
+
+	result, err := cs.search(ctx, query, area, near, opts)
+	span.SetAttributes(attribute.Int("citysearch.candidates", len(result)))
+	return result, err
+}
+
+// rankTraced is rank, in a span of its own
+func (cs *CitySearcher) rankTraced(ctx context.Context, result []CityWithScore, opts SearchOptions) []CityWithScore {
+	_, span := startSpan(ctx, "rank")
+	defer span.End()
+
+	ranked := rank(result, opts)
+	span.SetAttributes(attribute.Int("citysearch.results", len(ranked)))
+	return ranked
+}
+
+// search gets every result for query in area (if not nil) scored on text
+// alone; best first, though in prefix mode that is prefix matches first
+// then the rest. If near is set, the cities nearest it are matched as well
+// as the index's candidates, whatever the query's length.
+func (cs *CitySearcher) search(ctx context.Context, query string, area Area, near *vec3, opts SearchOptions) ([]CityWithScore, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	query = Normalise(query)
+	if query == "" {
+		// punctuation and spaces normalise away, and nothing
+		// left to match would match every name there is
+		return []CityWithScore{}, nil
+	}
+
+	var result []CityWithScore
+	seen := make(map[int32]bool)
+	add := func(matches []match, prefix bool) error {
+		if result == nil {
+			result = make([]CityWithScore, 0, len(matches))
+		}
+		for i, v := range matches {
+			if err := cancelled(ctx, i); err != nil {
+				return err
+			}
+			// matches are best first, so the first name seen for a city is its best
+			ref := cs.index.refs[v.id]
+			if seen[ref.city] {
+				continue
+			}
+			seen[ref.city] = true
+
+			// the index's and the nearby cities' matches are all
+			// allowed, but the typo matches aren't limited
+			c := &cs.cities[ref.city]
+			if !cs.allows(ref.city, area, opts) {
+				continue
+			}
+			result = append(result, CityWithScore{
+				City:        *c,
+				MatchedName: c.variant(ref.variant),
+				// Levenshtein is 0 for a perfect match, so +1 to avoid /0
+				Score:  1 / float64(v.distance+1),
+				prefix: prefix,
+				city:   ref.city,
+			})
+		}
+		return nil
+	}
+
+	// the index only matches so many names, so the limits on the search have
+	// to be checked as it picks them, or names they rule out could take the
+	// places of those they don't. Countries have partitions of their own;
+	// the other limits are checked name by name, which isn't free, so only
+	// when there are any.
+	parts := cs.index.in(opts.Countries)
+	var keep func(id int32) bool
+	if len(opts.Admin1) > 0 || opts.Filter != nil || area != nil {
+		keep = func(id int32) bool {
+			return cs.allows(cs.index.refs[id].city, area, opts)
+		}
+	}
+
+	// the index picks names on the text alone (and for a letter or two,
+	// only the biggest places' names), so the nearest cities, the likeliest
+	// to be wanted, are matched as well in case it didn't pick them
+	var nearMatches, nearPrefixes []match
+	if near != nil {
+		nearMatches, nearPrefixes = cs.matchNearby(query, cs.nearby(*near, nearbyCandidates, area, opts), opts.Mode == ModePrefix)
+	}
+
+	if opts.Mode == ModePrefix {
+		matches, err := cs.index.findPrefix(ctx, query, parts, keep)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, nearPrefixes...)
+		sortMatches(matches)
+		if err := add(matches, true); err != nil {
+			return nil, err
+		}
+		// prefix matches always rank first, so if they fill
+		// the page there's no need to look any further
+		if opts.Limit > 0 && len(result) >= opts.Offset+opts.Limit {
+			return result, nil
+		}
+	}
+
+	matches, err := cs.index.find(ctx, query, parts, keep)
+	if err != nil {
+		return nil, err
+	}
+	matches = append(matches, nearMatches...)
+	if opts.Mode == ModeTypo {
+		typos, err := cs.index.findTypos(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, typos...)
+	}
+	// a name can be found more than once, but the closer match comes
+	// first, and is the one add keeps
+	sortMatches(matches)
+	if err := add(matches, false); err != nil {
+		return nil, err
+	}
+
+	if result == nil {
+		result = []CityWithScore{}
 	}
+	return result, nil
+}
 
-	return &CitySearcher{
-		cities, cityNames,
-	}, nil
+// allows is whether city passes every limit on the search but the query:
+// its country, admin1, filter and area
+func (cs *CitySearcher) allows(city int32, area Area, opts SearchOptions) bool {
+	c := &cs.cities[city]
+	if !opts.inCountries(c) || !opts.inAdmin1(c) || (opts.Filter != nil && !opts.Filter(c)) {
+		return false
+	}
+	return area == nil || area.Contains(c.Lat, c.Lng)
 }
 
-// Search gets scored result suggestions for query. Scores are inversely proportional to the Levenshtein distance
-func (cs *CitySearcher) Search(ctx context.Context, query string) ([]CityWithScore, error) {
-	ranks := fuzzy.RankFind(strings.ToLower(query), cs.cityNames)
-	result := make([]CityWithScore, len(ranks))
-	for i, v := range ranks {
-		result[i] = CityWithScore{
-			// cs.cities is in the same order as cs.cityNames, so match by index
-			City: cs.cities[v.OriginalIndex],
-			// Levenshtein is 0 for a perfect match, so +1 to avoid /0
-			Score: 1 / float64(v.Distance+1),
+// nearbyCandidates is how many of the cities nearest the location searched
+// from are matched alongside the index's candidates. They only need to
+// cover the places nearby enough for distance to outweigh a better name.
+const nearbyCandidates = 100
+
+// nearby returns the k cities nearest to pos that the search allows,
+// nearest first
+func (cs *CitySearcher) nearby(pos vec3, k int, area Area, opts SearchOptions) []kdNeighbour {
+	return cs.spatial.nearest(pos, k, func(city int32) bool {
+		return cs.allows(city, area, opts)
+	})
+}
+
+// matchNearby matches query against every name of the nearby cities. If
+// prefixes is set, the names with a word starting with the query are
+// returned separately, as for findPrefix; otherwise they are in matches.
+// Both are ordered best first, ties broken by position in the index.
+func (cs *CitySearcher) matchNearby(query string, nearby []kdNeighbour, prefixes bool) ([]match, []match) {
+	qLen := utf8.RuneCountInString(query)
+
+	var matches, prefixMatches []match
+	for _, n := range nearby {
+		for id := cs.cityNames[n.city]; id < cs.cityNames[n.city+1]; id++ {
+			name := cs.index.names[id]
+			if prefixes && startsWord(name, query) {
+				// as in findPrefix, the distance is just how many more runes the name has
+				prefixMatches = append(prefixMatches, match{id, utf8.RuneCountInString(name) - qLen})
+			} else if d := subsequenceDistance(query, name); d >= 0 {
+				matches = append(matches, match{id, d})
+			}
 		}
 	}
+	sortMatches(matches)
+	sortMatches(prefixMatches)
+	return matches, prefixMatches
+}
+
+// score replaces v's text score with its final score from the searcher's
+// Scorer, given the features other than text and population in f
+func (cs *CitySearcher) score(v *CityWithScore, f Features, mode Mode) {
+	f.Text = v.Score
+	f.Population = cs.population.score(v.Population)
+	f.City = &v.City
+	v.Score = cs.scorer.Score(f)
+
+	if mode == ModePrefix {
+		// keep prefix matches in the top half, and everything else below
+		v.Score = math.Max(0, math.Min(1, v.Score)) / 2
+		if v.prefix {
+			v.Score += 0.5
+		}
+	}
+}
+
+// City returns the city with the GeoNames ID id, or ErrCityNotFound if
+// there isn't one
+func (cs *CitySearcher) City(id string) (City, error) {
+	i, ok := cs.byID[id]
+	if !ok {
+		return City{}, ErrCityNotFound
+	}
+	return cs.cities[i], nil
+}
 
+// CityWithDistance is a city found near a point, and how far from it it is
+type CityWithDistance struct {
+	City
+	DistanceKm float64
+}
+
+// Nearest returns the k cities closest to lat/lng, closest first, for
+// reverse geocoding. Distances are along the surface of the earth.
+func (cs *CitySearcher) Nearest(ctx context.Context, lat, lng float64, k int) ([]CityWithDistance, error) {
+	// a k-d tree search is quick enough not to need checking part way through
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	neighbours := cs.spatial.nearest(toVec3(lat, lng), k, nil)
+
+	result := make([]CityWithDistance, len(neighbours))
+	for i, n := range neighbours {
+		result[i] = CityWithDistance{cs.cities[n.city], chordToKm(n.dist2)}
+	}
 	return result, nil
 }
 
-// Search gets scored result suggestions for query, modulated by their proximity to lat/lng
-func (cs *CitySearcher) SearchWithLocation(ctx context.Context, query string, lat, lng float64) ([]CityWithScore, error) {
-	result, err := cs.Search(ctx, query)
+// SearchWithLocation gets scored result suggestions for query, modulated by their proximity to lat/lng
+// (how much is up to the searcher's Scorer)
+func (cs *CitySearcher) SearchWithLocation(ctx context.Context, query string, lat, lng float64, opts SearchOptions) ([]CityWithScore, error) {
+	return cs.SearchWithLocationWithin(ctx, query, lat, lng, nil, opts)
+}
+
+// SearchWithLocationWithin is SearchWithLocation, only returning cities
+// inside area (or anywhere if area is nil)
+func (cs *CitySearcher) SearchWithLocationWithin(ctx context.Context, query string, lat, lng float64, area Area, opts SearchOptions) (_ []CityWithScore, err error) {
+	// like the access log, the span never says where the caller is
+	ctx, span := startSearchSpan(ctx, "CitySearcher.SearchWithLocation", query, area, opts)
+	defer func() { endSpan(span, err) }()
+	here := toVec3(lat, lng)
+
+	// every match needs rescoring before it is known which ones make the page
+	result, err := cs.searchTraced(ctx, query, area, &here, opts)
 	if err != nil {
 		return nil, err
 	}
 
+	_, scoring := startSpan(ctx, "score")
+
 	distances := make([]float64, len(result))
 	var min float64 = 1000 // 1000km is larger than GB
 	for i, v := range result {
-		// manhattan or euclidean distance wouldn't work here
-		// since degrees in lat/lon are different sizes (and not all constant)
-		_, km := haversine.Distance(
-			haversine.Coord{Lat: lat, Lon: lng},
-			haversine.Coord{Lat: v.Lat, Lon: v.Lng},
-		)
+		// manhattan or euclidean distance on lat/lon wouldn't work here
+		// since degrees are different sizes (and not all constant), but
+		// the straight line between the points on a sphere does
+		km := chordToKm(cs.positions[v.city].dist2(here))
 
 		distances[i] = km
 		if km < min {
@@ -129,17 +616,19 @@ func (cs *CitySearcher) SearchWithLocation(ctx context.Context, query string, la
 		}
 	}
 
-	for i, v := range result {
-		// this is an arbitrary combining of the scores. future work
-		// could improve this by tuning coefficiencs (or mixing nonlinearly)
-
-		// 0 is best distance, add 1 to avoid /0
-		distanceScore := math.Max(1, min) / (distances[i] + 1)
-		result[i].Score = (v.Score + distanceScore) / 2
+	for i := range result {
+		if err := cancelled(ctx, i); err != nil {
+			endSpan(scoring, err)
+			return nil, err
+		}
+		cs.score(&result[i], Features{
+			HasLocation: true,
+			DistanceKm:  distances[i],
+			NearestKm:   min,
+		}, opts.Mode)
 	}
+	scoring.End()
 
-	// re-sort taking into account distance scores
-	sort.Slice(result, func(i, j int) bool { return result[i].Score > result[j].Score })
-
-	return result, nil
+	// re-rank taking into account distance and population scores
+	return cs.rankTraced(ctx, result, opts), nil
 }
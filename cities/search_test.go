@@ -2,8 +2,10 @@ package cities_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
+	"math/rand"
 	"reflect"
 	"strings"
 	"testing"
@@ -134,22 +136,65 @@ func TestParsingErrors(t *testing.T) {
 	}
 }
 
+func TestParsingFullSchema(t *testing.T) {
+	csv := `geonameid,name,asciiname,alternatenames,latitude,longitude,feature class,feature code,country code,cc2,admin1 code,admin2 code,admin3 code,admin4 code,population,elevation,dem,timezone,modification date
+2641591,Newport,Newport,"Casnewydd,Newport",51.58774,-2.99835,P,PPLA2,GB,,WLS,X6,,,145700,,12,Europe/London,2021-09-15
+2641589,Newport,Newport,,50.70000,-1.29000,P,PPL,GB,,ENG,W5,,,25496,15,21,Europe/London,2018-07-03
+`
+	expected := cities.City{
+		GeoNameID:        "2641591",
+		Name:             "Newport",
+		ASCIIName:        "Newport",
+		AlternateNames:   cities.Names{"Casnewydd", "Newport"},
+		Lat:              51.58774,
+		Lng:              -2.99835,
+		FeatureClass:     "P",
+		FeatureCode:      "PPLA2",
+		CountryCode:      "GB",
+		Admin1Code:       "WLS",
+		Admin2Code:       "X6",
+		Population:       145700,
+		DEM:              12,
+		Timezone:         "Europe/London",
+		ModificationDate: "2021-09-15",
+	}
+
+	cs, err := cities.NewCitySearcher(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	results, err := cs.Search(context.Background(), "Newport", cities.SearchOptions{})
+	if err != nil {
+		t.Fatalf("failed to search: %s", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected both Newports, got %v", results)
+	}
+	if !reflect.DeepEqual(expected, results[0].City) {
+		t.Fatalf("expected %+v, got %+v", expected, results[0].City)
+	}
+	if results[1].Admin1Code != "ENG" || results[1].Elevation != 15 || results[1].AlternateNames != nil {
+		t.Fatalf("expected the second Newport to be in ENG at 15m with no alternate names, got %+v", results[1].City)
+	}
+}
+
 func TestSearch(t *testing.T) {
 	citySample := `geonameid,name,asciiname,alternatenames,latitude,longitude,feature class,feature code,country code,cc2,admin1 code,admin2 code,admin3 code,admin4 code,population,elevation,dem,timezone,modification date
-2633485,Wrexham,Wrexham,"Reksamas,Reksem,Reksum,Rexam,Wrecsam,Wreksam,Wrexham,legseom,lei ke si han mu,rekusamu,wrksam,Œ°Œ≠ŒæŒ±Œº,–†–µ–∫—Å–µ–º,–†–µ–∫—Å—ä–º,’å’•÷Ñ’Ω’∞’•’¥,◊®◊ß◊°◊î◊ê◊ù,Ÿàÿ±⁄©ÿ≥ÿßŸÖ,„É¨„ÇØ„Çµ„É†,Èõ∑ÂÖãÊñØÊº¢ÂßÜ,Î†âÏÑ¨",53.04664,-2.99132,P,PPLA2,GB,,WLS,Z4,00NL007,,65692,,87,Europe/London,12/06/2017
-2633521,Worthing,Worthing,"Vorting,Worthing,wajingu,wwrtyng,–í–æ—Ä—Ç–∏–Ω–≥,ŸàŸàÿ±ÿ™€åŸÜ⁄Ø,„ÉØ„Éº„Ç∏„É≥„Ç∞",50.81795,-0.37538,P,PPL,GB,,ENG,P6,45UH,,99110,,7,Europe/London,14/09/2014
-2633551,Worksop,Worksop,"Uehrksop,Uurksop,Worksop,wwrksap,–£—ä—Ä–∫—Å–æ–ø,–£—ç—Ä–∫—Å–æ–ø,ŸàŸàÿ±⁄©ÿ≥ÿßŸæ",53.30182,-1.12404,P,PPL,GB,,ENG,J9,37UC,,43252,,46,Europe/London,12/06/2017
-2633553,Workington,Workington,"Uurkingtun,wo jin dun,wwrkyngtwn,–£—ä—Ä–∫–∏–Ω–≥—Ç—ä–Ω,ŸàŸàÿ±⁄©€åŸÜ⁄Øÿ™ŸàŸÜ,Ê≤ÉÈáëÈ†ì",54.6425,-3.54413,P,PPL,GB,,ENG,C9,16UB,16UB061,27120,,20,Europe/London,03/07/2018
-2633563,Worcester,Worcester,"Caerwrangon,City of Worcester,UWC,Ustur,Vigornia,Vustehr,Vuster,Vusteris,Wiogoraceastre,Worcester,useuteo,usuta,vuster,wo shi da,wrkstr,wstr,wu si te,wurs texr,wwstr,–í—É—Å—Ç–µ—Ä,–í—É—Å—Ç—ç—Ä,–£—Å—Ç—ä—Ä,’é’∏÷Ç’Ω’©’•÷Ä,Ÿàÿ±⁄©ÿ≥ÿ™ÿ±,Ÿàÿ≥ÿ™ÿ±,ŸàŸàÿ±ÿ≥ÿ≥Ÿπÿ±,ŸàŸàÿ≥ÿ™ÿ±,ŸàŸàÿ≥Ÿπÿ±,‡∏ß‡∏∏‡∏£‡πå‡∏™‡πÄ‡∏ï‡∏≠‡∏£‡πå,„Ç¶„Çπ„Çø„Éº,‰ºçÊñØÁâπ,Á™©Â£´Êâì,Ïö∞Ïä§ÌÑ∞",52.18935,-2.22001,P,PPLA2,GB,,ENG,Q4,47UE,,101659,,29,Europe/London,05/09/2019
-2633655,Woodford Green,Woodford Green,"Woodford Green,vudafarda grina,‡§µ‡•Å‡§°‡§´‡§º‡§∞‡•ç‡§° ‡§ó‡•ç‡§∞‡•Ä‡§®",51.60938,0.02329,P,PPL,GB,,ENG,GLA,K8,,22803,,62,Europe/London,18/05/2012
+2633485,Wrexham,Wrexham,"Reksamas,Reksem,Reksum,Rexam,Wrecsam,Wreksam,Wrexham,legseom,lei ke si han mu,rekusamu,wrksam,Ρέξαμ,Рексем,Рексъм,Ռեքսհեմ,רקסהאם,ورکسام,レクサム,雷克斯漢姆,렉섬",53.04664,-2.99132,P,PPLA2,GB,,WLS,Z4,00NL007,,65692,,87,Europe/London,12/06/2017
+2633521,Worthing,Worthing,"Vorting,Worthing,wajingu,wwrtyng,Вортинг,وورتینگ,ワージング",50.81795,-0.37538,P,PPL,GB,,ENG,P6,45UH,,99110,,7,Europe/London,14/09/2014
+2633551,Worksop,Worksop,"Uehrksop,Uurksop,Worksop,wwrksap,Уърксоп,Уэрксоп,وورکساپ",53.30182,-1.12404,P,PPL,GB,,ENG,J9,37UC,,43252,,46,Europe/London,12/06/2017
+2633553,Workington,Workington,"Uurkingtun,wo jin dun,wwrkyngtwn,Уъркингтън,وورکینگتون,沃金頓",54.6425,-3.54413,P,PPL,GB,,ENG,C9,16UB,16UB061,27120,,20,Europe/London,03/07/2018
+2633563,Worcester,Worcester,"Caerwrangon,City of Worcester,UWC,Ustur,Vigornia,Vustehr,Vuster,Vusteris,Wiogoraceastre,Worcester,useuteo,usuta,vuster,wo shi da,wrkstr,wstr,wu si te,wurs texr,wwstr,Вустер,Вустэр,Устър,Վուսթեր,ورکستر,وستر,وورسسٹر,ووستر,ووسٹر,วุร์สเตอร์,ウスター,伍斯特,窩士打,우스터",52.18935,-2.22001,P,PPLA2,GB,,ENG,Q4,47UE,,101659,,29,Europe/London,05/09/2019
+2633655,Woodford Green,Woodford Green,"Woodford Green,vudafarda grina,वुडफ़र्ड ग्रीन",51.60938,0.02329,P,PPL,GB,,ENG,GLA,K8,,22803,,62,Europe/London,18/05/2012
 2633681,Wombwell,Wombwell,Wombwell,53.52189,-1.39698,P,PPL,GB,,ENG,A3,,,15518,,53,Europe/London,11/07/2013
-2633708,Wokingham,Wokingham,"Uokingam,Uokingkhem,oking-eom,wwkyngham,–£–æ–∫–∏–Ω–≥–∞–º,–£–æ–∫–∏–Ω–≥—Ö–µ–º,ŸàŸà⁄©€åŸÜ⁄ØŸáÿßŸÖ,Ïò§ÌÇπÏóÑ",51.4112,-0.83565,P,PPLA2,GB,,ENG,Q2,00MF015,,41143,,72,Europe/London,22/06/2016
-2633709,Woking,Woking,"Uoking,Uokinge,Vokingas,Woking,XWO,u~okingu,wo jin,wwdkyng,wwkng,–£–æ–∫–∏–Ω–≥,–£–æ–∫–∏–Ω–≥–µ,ŸàŸàÿØ⁄©€åŸÜ⁄Ø,ŸàŸà⁄©ŸÜ⁄Ø,„Ç¶„Ç©„Ç≠„É≥„Ç∞,Ê≤ÉÈáë",51.31903,-0.55893,P,PPL,GB,,ENG,N7,43UM,,103932,,39,Europe/London,03/08/2010
-2633729,Witney,Witney,"Uitni,Witney,wytny,–£–∏—Ç–Ω–∏,Ÿà€åÿ™ŸÜ€å",51.7836,-1.4854,P,PPL,GB,,ENG,K2,38UF,38UF080,29103,,87,Europe/London,03/07/2018
-2633749,Witham,Witham,"wytham,Ÿà€åÿ™ŸáÿßŸÖ",51.80007,0.64038,P,PPL,GB,,ENG,E4,22UC,22UC063,25353,,25,Europe/London,03/07/2018
-2633765,Wishaw,Wishaw,"Camas Neachdain,Vishou,Wishae,Wishaw,wei xiao,wyshaw,–í—ñ—à–æ—É,Ÿà€åÿ¥ÿßŸà,Â®ÅËï≠",55.76667,-3.91667,P,PPL,GB,,SCT,V8,,,30510,,138,Europe/London,12/06/2017
-2633771,Wisbech,Wisbech,"Uisbijch,Visbicas,Visbiƒças,Vizbich,Wisbech,wysbch,–í–∏–∑–±–∏—á,–£–∏—Å–±–∏–π—á,Ÿà€åÿ≥ÿ®⁄Ü",52.66622,0.15938,P,PPL,GB,,ENG,C3,12UD,12UD014,32489,,6,Europe/London,03/07/2018
-2633810,Winsford,Winsford,"wynsfwrd,Ÿà€åŸÜÿ≥ŸÅŸàÿ±ÿØ",53.19146,-2.52398,P,PPLA3,GB,,ENG,Z8,00EW163,,30259,,36,Europe/London,13/06/2017
+2633708,Wokingham,Wokingham,"Uokingam,Uokingkhem,oking-eom,wwkyngham,Уокингам,Уокингхем,ووکینگهام,오킹엄",51.4112,-0.83565,P,PPLA2,GB,,ENG,Q2,00MF015,,41143,,72,Europe/London,22/06/2016
+2633709,Woking,Woking,"Uoking,Uokinge,Vokingas,Woking,XWO,u~okingu,wo jin,wwdkyng,wwkng,Уокинг,Уокинге,وودکینگ,ووکنگ,ウォキング,沃金",51.31903,-0.55893,P,PPL,GB,,ENG,N7,43UM,,103932,,39,Europe/London,03/08/2010
+2633729,Witney,Witney,"Uitni,Witney,wytny,Уитни,ویتنی",51.7836,-1.4854,P,PPL,GB,,ENG,K2,38UF,38UF080,29103,,87,Europe/London,03/07/2018
+2633749,Witham,Witham,"wytham,ویتهام",51.80007,0.64038,P,PPL,GB,,ENG,E4,22UC,22UC063,25353,,25,Europe/London,03/07/2018
+2633765,Wishaw,Wishaw,"Camas Neachdain,Vishou,Wishae,Wishaw,wei xiao,wyshaw,Вішоу,ویشاو,威蕭",55.76667,-3.91667,P,PPL,GB,,SCT,V8,,,30510,,138,Europe/London,12/06/2017
+2633771,Wisbech,Wisbech,"Uisbijch,Visbicas,Visbičas,Vizbich,Wisbech,wysbch,Визбич,Уисбийч,ویسبچ",52.66622,0.15938,P,PPL,GB,,ENG,C3,12UD,12UD014,32489,,6,Europe/London,03/07/2018
+2633810,Winsford,Winsford,"wynsfwrd,وینسفورد",53.19146,-2.52398,P,PPLA3,GB,,ENG,Z8,00EW163,,30259,,36,Europe/London,13/06/2017
 `
 
 	type test struct {
@@ -218,7 +263,7 @@ func TestSearch(t *testing.T) {
 				t.Fatalf("failed to make city searcher: %s", err)
 			}
 
-			results, err := cs.Search(context.Background(), tc.query)
+			results, err := cs.Search(context.Background(), tc.query, cities.SearchOptions{})
 			if err != nil {
 				t.Fatalf("failed to  search: %s", err)
 			}
@@ -228,22 +273,560 @@ func TestSearch(t *testing.T) {
 	}
 }
 
+func TestSearchCountries(t *testing.T) {
+	csv := `geonameid,name,latitude,longitude,country code,admin1 code
+2643743,London,51.50853,-0.12574,GB,ENG
+2643736,Londonderry County Borough,54.99721,-7.30917,GB,NIR
+6058560,London,42.98339,-81.23304,CA,08
+2962974,Londonbridge,53.3,-6.2,IE,L
+`
+
+	type test struct {
+		name string
+		// query is "london" if not set
+		query    string
+		opts     cities.SearchOptions
+		expected []string
+	}
+
+	cases := []test{
+		{
+			name:     "every country by default",
+			expected: []string{"2643743", "6058560", "2962974", "2643736"},
+		},
+		{
+			name:     "one country",
+			opts:     cities.SearchOptions{Countries: []string{"GB"}},
+			expected: []string{"2643743", "2643736"},
+		},
+		{
+			name:     "several countries, in any order or case",
+			opts:     cities.SearchOptions{Countries: []string{"ie", "CA", "IE"}},
+			expected: []string{"6058560", "2962974"},
+		},
+		{
+			name:     "unknown country",
+			opts:     cities.SearchOptions{Countries: []string{"XX"}},
+			expected: nil,
+		},
+		{
+			name:     "admin1 within a country",
+			opts:     cities.SearchOptions{Countries: []string{"GB"}, Admin1: []string{"nir"}},
+			expected: []string{"2643736"},
+		},
+		{
+			name:     "admin1 alone",
+			opts:     cities.SearchOptions{Admin1: []string{"ENG", "08"}},
+			expected: []string{"2643743", "6058560"},
+		},
+		{
+			name:     "short query in several countries",
+			query:    "ln",
+			opts:     cities.SearchOptions{Countries: []string{"CA", "IE"}},
+			expected: []string{"6058560", "2962974"},
+		},
+		{
+			name:     "prefix mode",
+			opts:     cities.SearchOptions{Countries: []string{"GB", "IE"}, Mode: cities.ModePrefix, Limit: 2},
+			expected: []string{"2643743", "2962974"},
+		},
+	}
+
+	cs, err := cities.NewCitySearcher(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			query := tc.query
+			if query == "" {
+				query = "london"
+			}
+			results, err := cs.Search(context.Background(), query, tc.opts)
+			if err != nil {
+				t.Fatalf("failed to search: %s", err)
+			}
+
+			var ids []string
+			for _, r := range results {
+				ids = append(ids, r.GeoNameID)
+			}
+			if !reflect.DeepEqual(tc.expected, ids) {
+				t.Fatalf("expected cities %v, got %v", tc.expected, ids)
+			}
+		})
+	}
+}
+
+func TestSearchWithLocationShortQuery(t *testing.T) {
+	// far more cities matching "l" than are worth scoring, in a line
+	// running north from the equator, each one further away and bigger
+	var sb strings.Builder
+	sb.WriteString("geonameid,name,latitude,longitude,population\n")
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&sb, "%d,Lake %d,%f,0,%d\n", i, i, float64(i)*0.01, 1000+i)
+	}
+	cs, err := cities.NewCitySearcher(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	for _, mode := range []cities.Mode{cities.ModeFuzzy, cities.ModePrefix} {
+		results, err := cs.SearchWithLocation(context.Background(), "l", 0, 0, cities.SearchOptions{Mode: mode})
+		if err != nil {
+			t.Fatalf("failed to search: %s", err)
+		}
+		if len(results) == 0 || len(results) >= 3000 {
+			t.Fatalf("expected only some cities to be matched, got %d", len(results))
+		}
+
+		// the nearest cities are matched as well as the biggest
+		found := make(map[string]bool)
+		for _, r := range results {
+			found[r.GeoNameID] = true
+		}
+		for _, id := range []string{"0", "1", "2999"} {
+			if !found[id] {
+				t.Fatalf("expected the nearest and the biggest cities in %s mode, but %s was missing", mode, id)
+			}
+		}
+		if results[0].GeoNameID != "0" {
+			t.Fatalf("expected the nearest city first in %s mode, got %s", mode, results[0].Name)
+		}
+	}
+
+	// limits on the search still apply to the nearby cities
+	results, err := cs.SearchWithLocation(context.Background(), "l", 0, 0, cities.SearchOptions{Filter: func(c *cities.City) bool { return c.Lat >= 10 }})
+	if err != nil {
+		t.Fatalf("failed to search: %s", err)
+	}
+	if len(results) == 0 || results[0].GeoNameID != "1000" {
+		t.Fatalf("expected the nearest city passing the filter first, got %+v", results)
+	}
+}
+
+func TestSearchWithLocationFarMatch(t *testing.T) {
+	// the nearest cities to Sydney don't match "lo" at all
+	var sb strings.Builder
+	sb.WriteString("geonameid,name,latitude,longitude,country code,population\n")
+	sb.WriteString("2643743,London,51.50853,-0.12574,GB,1000000000\n")
+	for i := 0; i < 1500; i++ {
+		fmt.Fprintf(&sb, "%d,Village %d,%f,151.2,AU,100\n", i, i, -33.9+float64(i)*0.001)
+	}
+	cs, err := cities.NewCitySearcher(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	// as the query is typed, London should be there all along
+	for _, query := range []string{"l", "lo", "lon", "lond"} {
+		for _, mode := range []cities.Mode{cities.ModeFuzzy, cities.ModePrefix, cities.ModeTypo} {
+			results, err := cs.SearchWithLocation(context.Background(), query, -33.86785, 151.20732, cities.SearchOptions{Mode: mode})
+			if err != nil {
+				t.Fatalf("failed to search: %s", err)
+			}
+			found := false
+			for _, r := range results {
+				found = found || r.Name == "London"
+			}
+			if !found {
+				t.Fatalf("expected London for %q in %s mode, got %v", query, mode, results)
+			}
+		}
+	}
+}
+
+func TestSearchManyCandidates(t *testing.T) {
+	// far more names share grams with "san" than are worth scoring, and
+	// they all come before (and are bigger than) most of the others
+	var sb strings.Builder
+	sb.WriteString("geonameid,name,latitude,longitude,country code,admin1 code,population\n")
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&sb, "%d,Santa Village %d,0,0,US,TX,200000\n", i, i)
+	}
+	sb.WriteString("5391959,San Francisco,37.77493,-122.41942,US,CA,864816\n")
+	sb.WriteString("2638419,Sandown,50.65058,-1.15473,GB,ENG,11000\n")
+	sb.WriteString("4172086,Sanford,28.80055,-81.27312,US,FL,500\n")
+	cs, err := cities.NewCitySearcher(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	type test struct {
+		name     string
+		area     cities.Area
+		opts     cities.SearchOptions
+		expected []string
+	}
+
+	cases := []test{
+		{
+			name:     "the best match is kept",
+			opts:     cities.SearchOptions{Limit: 1},
+			expected: []string{"San Francisco"},
+		},
+		{
+			name:     "other countries don't crowd out the one asked for",
+			opts:     cities.SearchOptions{Countries: []string{"GB"}},
+			expected: []string{"Sandown"},
+		},
+		{
+			name:     "nor other regions",
+			opts:     cities.SearchOptions{Admin1: []string{"FL"}},
+			expected: []string{"Sanford"},
+		},
+		{
+			name:     "nor cities the filter rules out",
+			opts:     cities.SearchOptions{Filter: func(c *cities.City) bool { return c.Population < 1000 }},
+			expected: []string{"Sanford"},
+		},
+		{
+			name:     "nor places outside the area",
+			area:     cities.BoundingBox{MinLat: 24.5, MinLng: -87.6, MaxLat: 31, MaxLng: -80},
+			expected: []string{"Sanford"},
+		},
+		{
+			name:     "nor places outside the radius",
+			area:     cities.Circle{Lat: 50.7, Lng: -1.2, RadiusKm: 50},
+			expected: []string{"Sandown"},
+		},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			results, err := cs.SearchWithin(context.Background(), "san", tc.area, tc.opts)
+			if err != nil {
+				t.Fatalf("failed to search: %s", err)
+			}
+
+			var names []string
+			for _, r := range results {
+				names = append(names, r.Name)
+			}
+			if !reflect.DeepEqual(tc.expected, names) {
+				t.Fatalf("expected %v, got %v", tc.expected, names)
+			}
+		})
+	}
+
+	// a single letter is in every name, so only some are matched, but
+	// they should be the biggest places
+	results, err := cs.Search(context.Background(), "a", cities.SearchOptions{})
+	if err != nil {
+		t.Fatalf("failed to search: %s", err)
+	}
+	if len(results) == 0 || len(results) >= 3003 {
+		t.Fatalf("expected only some cities to be matched, got %d", len(results))
+	}
+	found := false
+	for _, r := range results {
+		found = found || r.Name == "San Francisco"
+	}
+	if !found {
+		t.Fatal("expected San Francisco to be matched")
+	}
+}
+
+func TestSearchLimitsMatchLoadingOnly(t *testing.T) {
+	// the names ruled out share more grams with "santa" than the GB ones
+	// do, and there are more of them than are worth scoring
+	var sb strings.Builder
+	sb.WriteString("geonameid,name,latitude,longitude,country code,admin1 code,population\n")
+	for i := 0; i < 1500; i++ {
+		fmt.Fprintf(&sb, "%d,Santa Village %d,0,0,US,TX,200000\n", i, i)
+	}
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&sb, "%d,Sable %d,0,0,US,TX,200000\n", 10000+i, i)
+	}
+	sb.WriteString("1,Xsantana,50,0,GB,ENG,1000\n")
+	sb.WriteString("2,Sabcntxa,51,0,GB,ENG,1000\n")
+	csv := sb.String()
+
+	// loading only the GB cities leaves nothing to crowd them out
+	only, err := cities.NewCitySearcher(strings.NewReader(csv), cities.OnlyGB)
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+	expected, err := only.Search(context.Background(), "santa", cities.SearchOptions{})
+	if err != nil {
+		t.Fatalf("failed to search: %s", err)
+	}
+	if len(expected) != 2 {
+		t.Fatalf("expected both GB cities to match, got %v", expected)
+	}
+
+	cs, err := cities.NewCitySearcher(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	type test struct {
+		name string
+		area cities.Area
+		opts cities.SearchOptions
+	}
+
+	cases := []test{
+		{name: "country", opts: cities.SearchOptions{Countries: []string{"GB"}}},
+		{name: "admin1", opts: cities.SearchOptions{Admin1: []string{"ENG"}}},
+		{name: "filter", opts: cities.SearchOptions{Filter: cities.OnlyGB}},
+		{name: "area", area: cities.BoundingBox{MinLat: 49, MinLng: -1, MaxLat: 52, MaxLng: 1}},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			results, err := cs.SearchWithin(context.Background(), "santa", tc.area, tc.opts)
+			if err != nil {
+				t.Fatalf("failed to search: %s", err)
+			}
+			if len(results) != len(expected) {
+				t.Fatalf("expected the same results as loading only GB, %v, got %v", expected, results)
+			}
+			for i := range results {
+				if results[i].Name != expected[i].Name || results[i].Score != expected[i].Score {
+					t.Fatalf("expected the same results as loading only GB, %v, got %v", expected, results)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchWithLocationManyCandidates(t *testing.T) {
+	// far more names share grams with "san" than are worth scoring, and
+	// they're all bigger than San Francisco, so it isn't one of the
+	// candidates the index picks
+	var sb strings.Builder
+	sb.WriteString("geonameid,name,latitude,longitude,population\n")
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&sb, "%d,Santa Village %d,0,0,1000000\n", i, i)
+	}
+	sb.WriteString("5391959,San Francisco,37.77493,-122.41942,864816\n")
+	cs, err := cities.NewCitySearcher(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	// but from right there, it's what's wanted
+	for _, mode := range []cities.Mode{cities.ModeFuzzy, cities.ModePrefix, cities.ModeTypo} {
+		results, err := cs.SearchWithLocation(context.Background(), "san", 37.77493, -122.41942, cities.SearchOptions{Limit: 1, Mode: mode})
+		if err != nil {
+			t.Fatalf("failed to search: %s", err)
+		}
+		if len(results) != 1 || results[0].Name != "San Francisco" {
+			t.Fatalf("expected San Francisco in %s mode, got %+v", mode, results)
+		}
+	}
+}
+
+func TestSearchSubsequence(t *testing.T) {
+	csv := `geonameid,name,latitude,longitude,population
+2643743,London,51.50853,-0.12574,8961989
+2633709,Woking,51.31903,-0.55893,103932
+`
+	cs, err := cities.NewCitySearcher(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	// none of these share a trigram with London, but its letters are in them in order
+	for _, query := range []string{"ldn", "lndn"} {
+		results, err := cs.Search(context.Background(), query, cities.SearchOptions{})
+		if err != nil {
+			t.Fatalf("failed to search: %s", err)
+		}
+		if len(results) != 1 || results[0].Name != "London" {
+			t.Fatalf("expected only London for %q, got %+v", query, results)
+		}
+	}
+}
+
+func TestSearchAlternateNames(t *testing.T) {
+	csv := `geonameid,name,asciiname,alternatenames,latitude,longitude,country code
+2643743,London,London,"Londinium,Londra,Londres,Lundúnir,Лондон,ロンドン",51.50853,-0.12574,GB
+2867714,Munich,Munich,"Minga,Monaco di Baviera,Munchen,München",48.13743,11.57549,DE
+6058560,London,London,"Londonas,Londres",42.98339,-81.23304,CA
+`
+
+	type test struct {
+		name          string
+		query         string
+		expectedIDs   []string
+		expectedNames []string
+	}
+
+	cases := []test{
+		{
+			name:          "main name matches",
+			query:         "London",
+			expectedIDs:   []string{"2643743", "6058560"},
+			expectedNames: []string{"London", "London"},
+		},
+		{
+			name:          "alternate name matches, once per city",
+			query:         "Londres",
+			expectedIDs:   []string{"2643743", "6058560"},
+			expectedNames: []string{"Londres", "Londres"},
+		},
+		{
+			name:          "non-ascii alternate name matches",
+			query:         "lundúnir",
+			expectedIDs:   []string{"2643743"},
+			expectedNames: []string{"Lundúnir"},
+		},
+		{
+			name:          "non-latin alternate name matches",
+			query:         "Лондон",
+			expectedIDs:   []string{"2643743"},
+			expectedNames: []string{"Лондон"},
+		},
+		{
+			name:          "transliterated alternate name matches",
+			query:         "Munchen",
+			expectedIDs:   []string{"2867714"},
+			expectedNames: []string{"Munchen"},
+		},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cs, err := cities.NewCitySearcher(strings.NewReader(csv))
+			if err != nil {
+				t.Fatalf("failed to make city searcher: %s", err)
+			}
+
+			results, err := cs.Search(context.Background(), tc.query, cities.SearchOptions{})
+			if err != nil {
+				t.Fatalf("failed to search: %s", err)
+			}
+
+			var ids, names []string
+			for _, r := range results {
+				ids = append(ids, r.GeoNameID)
+				names = append(names, r.MatchedName)
+			}
+			if !reflect.DeepEqual(tc.expectedIDs, ids) {
+				t.Fatalf("expected cities %v, got %v", tc.expectedIDs, ids)
+			}
+			if !reflect.DeepEqual(tc.expectedNames, names) {
+				t.Fatalf("expected matched names %v, got %v", tc.expectedNames, names)
+			}
+		})
+	}
+}
+
+func TestSearchNormalisesNames(t *testing.T) {
+	csv := `geonameid,name,latitude,longitude
+2657970,Zürich,47.36667,8.55
+1853909,Ōsaka,34.69374,135.50218
+2638867,St Albans,51.75,-0.33333
+745044,İstanbul,41.01384,28.94966
+`
+
+	type test struct {
+		name     string
+		query    string
+		expected string
+	}
+
+	cases := []test{
+		{name: "query without diacritics", query: "Zurich", expected: "Zürich"},
+		{name: "query with diacritics", query: "ZÜRICH", expected: "Zürich"},
+		{name: "query without macron", query: "osaka", expected: "Ōsaka"},
+		{name: "query with abbreviation spelled out", query: "Saint Albans", expected: "St Albans"},
+		{name: "query with abbreviation and punctuation", query: "st. albans", expected: "St Albans"},
+		{name: "query with turkish capital dotted I", query: "İSTANBUL", expected: "İstanbul"},
+		{name: "query in lower case", query: "istanbul", expected: "İstanbul"},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cs, err := cities.NewCitySearcher(strings.NewReader(csv))
+			if err != nil {
+				t.Fatalf("failed to make city searcher: %s", err)
+			}
+
+			results, err := cs.Search(context.Background(), tc.query, cities.SearchOptions{})
+			if err != nil {
+				t.Fatalf("failed to search: %s", err)
+			}
+			if len(results) == 0 || results[0].Name != tc.expected {
+				t.Fatalf("expected the first result to be %s, got %v", tc.expected, results)
+			}
+			if results[0].Score != 1.0 {
+				t.Fatalf("expected an exact match, but got score %f", results[0].Score)
+			}
+		})
+	}
+}
+
+func TestSearchNothingToMatch(t *testing.T) {
+	csv := `geonameid,name,latitude,longitude
+2643743,London,51.50853,-0.12574
+2638867,St. Albans,51.75,-0.33333
+`
+
+	cases := []string{".", "-", "  ", "'. ,"}
+	modes := []cities.Mode{cities.ModeFuzzy, cities.ModePrefix, cities.ModeTypo}
+
+	cs, err := cities.NewCitySearcher(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	for _, q := range cases {
+		query := q
+		for _, m := range modes {
+			mode := m
+			t.Run(fmt.Sprintf("%q %s", query, mode), func(t *testing.T) {
+				t.Parallel()
+				opts := cities.SearchOptions{Mode: mode}
+				results, err := cs.Search(context.Background(), query, opts)
+				if err != nil {
+					t.Fatalf("failed to search: %s", err)
+				}
+				if len(results) != 0 {
+					t.Fatalf("expected no results for a query of only punctuation, got %v", results)
+				}
+
+				results, err = cs.SearchWithLocation(context.Background(), query, 51.5, -0.1, opts)
+				if err != nil {
+					t.Fatalf("failed to search with location: %s", err)
+				}
+				if len(results) != 0 {
+					t.Fatalf("expected no results for a query of only punctuation with a location, got %v", results)
+				}
+			})
+		}
+	}
+}
+
 func TestSearchWithLocation(t *testing.T) {
 	citySample := `geonameid,name,asciiname,alternatenames,latitude,longitude,feature class,feature code,country code,cc2,admin1 code,admin2 code,admin3 code,admin4 code,population,elevation,dem,timezone,modification date
-2633485,Wrexham,Wrexham,"Reksamas,Reksem,Reksum,Rexam,Wrecsam,Wreksam,Wrexham,legseom,lei ke si han mu,rekusamu,wrksam,Œ°Œ≠ŒæŒ±Œº,–†–µ–∫—Å–µ–º,–†–µ–∫—Å—ä–º,’å’•÷Ñ’Ω’∞’•’¥,◊®◊ß◊°◊î◊ê◊ù,Ÿàÿ±⁄©ÿ≥ÿßŸÖ,„É¨„ÇØ„Çµ„É†,Èõ∑ÂÖãÊñØÊº¢ÂßÜ,Î†âÏÑ¨",53.04664,-2.99132,P,PPLA2,GB,,WLS,Z4,00NL007,,65692,,87,Europe/London,12/06/2017
-2633521,Worthing,Worthing,"Vorting,Worthing,wajingu,wwrtyng,–í–æ—Ä—Ç–∏–Ω–≥,ŸàŸàÿ±ÿ™€åŸÜ⁄Ø,„ÉØ„Éº„Ç∏„É≥„Ç∞",50.81795,-0.37538,P,PPL,GB,,ENG,P6,45UH,,99110,,7,Europe/London,14/09/2014
-2633551,Worksop,Worksop,"Uehrksop,Uurksop,Worksop,wwrksap,–£—ä—Ä–∫—Å–æ–ø,–£—ç—Ä–∫—Å–æ–ø,ŸàŸàÿ±⁄©ÿ≥ÿßŸæ",53.30182,-1.12404,P,PPL,GB,,ENG,J9,37UC,,43252,,46,Europe/London,12/06/2017
-2633553,Workington,Workington,"Uurkingtun,wo jin dun,wwrkyngtwn,–£—ä—Ä–∫–∏–Ω–≥—Ç—ä–Ω,ŸàŸàÿ±⁄©€åŸÜ⁄Øÿ™ŸàŸÜ,Ê≤ÉÈáëÈ†ì",54.6425,-3.54413,P,PPL,GB,,ENG,C9,16UB,16UB061,27120,,20,Europe/London,03/07/2018
-2633563,Worcester,Worcester,"Caerwrangon,City of Worcester,UWC,Ustur,Vigornia,Vustehr,Vuster,Vusteris,Wiogoraceastre,Worcester,useuteo,usuta,vuster,wo shi da,wrkstr,wstr,wu si te,wurs texr,wwstr,–í—É—Å—Ç–µ—Ä,–í—É—Å—Ç—ç—Ä,–£—Å—Ç—ä—Ä,’é’∏÷Ç’Ω’©’•÷Ä,Ÿàÿ±⁄©ÿ≥ÿ™ÿ±,Ÿàÿ≥ÿ™ÿ±,ŸàŸàÿ±ÿ≥ÿ≥Ÿπÿ±,ŸàŸàÿ≥ÿ™ÿ±,ŸàŸàÿ≥Ÿπÿ±,‡∏ß‡∏∏‡∏£‡πå‡∏™‡πÄ‡∏ï‡∏≠‡∏£‡πå,„Ç¶„Çπ„Çø„Éº,‰ºçÊñØÁâπ,Á™©Â£´Êâì,Ïö∞Ïä§ÌÑ∞",52.18935,-2.22001,P,PPLA2,GB,,ENG,Q4,47UE,,101659,,29,Europe/London,05/09/2019
-2633655,Woodford Green,Woodford Green,"Woodford Green,vudafarda grina,‡§µ‡•Å‡§°‡§´‡§º‡§∞‡•ç‡§° ‡§ó‡•ç‡§∞‡•Ä‡§®",51.60938,0.02329,P,PPL,GB,,ENG,GLA,K8,,22803,,62,Europe/London,18/05/2012
+2633485,Wrexham,Wrexham,"Reksamas,Reksem,Reksum,Rexam,Wrecsam,Wreksam,Wrexham,legseom,lei ke si han mu,rekusamu,wrksam,Ρέξαμ,Рексем,Рексъм,Ռեքսհեմ,רקסהאם,ورکسام,レクサム,雷克斯漢姆,렉섬",53.04664,-2.99132,P,PPLA2,GB,,WLS,Z4,00NL007,,65692,,87,Europe/London,12/06/2017
+2633521,Worthing,Worthing,"Vorting,Worthing,wajingu,wwrtyng,Вортинг,وورتینگ,ワージング",50.81795,-0.37538,P,PPL,GB,,ENG,P6,45UH,,99110,,7,Europe/London,14/09/2014
+2633551,Worksop,Worksop,"Uehrksop,Uurksop,Worksop,wwrksap,Уърксоп,Уэрксоп,وورکساپ",53.30182,-1.12404,P,PPL,GB,,ENG,J9,37UC,,43252,,46,Europe/London,12/06/2017
+2633553,Workington,Workington,"Uurkingtun,wo jin dun,wwrkyngtwn,Уъркингтън,وورکینگتون,沃金頓",54.6425,-3.54413,P,PPL,GB,,ENG,C9,16UB,16UB061,27120,,20,Europe/London,03/07/2018
+2633563,Worcester,Worcester,"Caerwrangon,City of Worcester,UWC,Ustur,Vigornia,Vustehr,Vuster,Vusteris,Wiogoraceastre,Worcester,useuteo,usuta,vuster,wo shi da,wrkstr,wstr,wu si te,wurs texr,wwstr,Вустер,Вустэр,Устър,Վուսթեր,ورکستر,وستر,وورسسٹر,ووستر,ووسٹر,วุร์สเตอร์,ウスター,伍斯特,窩士打,우스터",52.18935,-2.22001,P,PPLA2,GB,,ENG,Q4,47UE,,101659,,29,Europe/London,05/09/2019
+2633655,Woodford Green,Woodford Green,"Woodford Green,vudafarda grina,वुडफ़र्ड ग्रीन",51.60938,0.02329,P,PPL,GB,,ENG,GLA,K8,,22803,,62,Europe/London,18/05/2012
 2633681,Wombwell,Wombwell,Wombwell,53.52189,-1.39698,P,PPL,GB,,ENG,A3,,,15518,,53,Europe/London,11/07/2013
-2633708,Wokingham,Wokingham,"Uokingam,Uokingkhem,oking-eom,wwkyngham,–£–æ–∫–∏–Ω–≥–∞–º,–£–æ–∫–∏–Ω–≥—Ö–µ–º,ŸàŸà⁄©€åŸÜ⁄ØŸáÿßŸÖ,Ïò§ÌÇπÏóÑ",51.4112,-0.83565,P,PPLA2,GB,,ENG,Q2,00MF015,,41143,,72,Europe/London,22/06/2016
-2633709,Woking,Woking,"Uoking,Uokinge,Vokingas,Woking,XWO,u~okingu,wo jin,wwdkyng,wwkng,–£–æ–∫–∏–Ω–≥,–£–æ–∫–∏–Ω–≥–µ,ŸàŸàÿØ⁄©€åŸÜ⁄Ø,ŸàŸà⁄©ŸÜ⁄Ø,„Ç¶„Ç©„Ç≠„É≥„Ç∞,Ê≤ÉÈáë",51.31903,-0.55893,P,PPL,GB,,ENG,N7,43UM,,103932,,39,Europe/London,03/08/2010
-2633729,Witney,Witney,"Uitni,Witney,wytny,–£–∏—Ç–Ω–∏,Ÿà€åÿ™ŸÜ€å",51.7836,-1.4854,P,PPL,GB,,ENG,K2,38UF,38UF080,29103,,87,Europe/London,03/07/2018
-2633749,Witham,Witham,"wytham,Ÿà€åÿ™ŸáÿßŸÖ",51.80007,0.64038,P,PPL,GB,,ENG,E4,22UC,22UC063,25353,,25,Europe/London,03/07/2018
-2633765,Wishaw,Wishaw,"Camas Neachdain,Vishou,Wishae,Wishaw,wei xiao,wyshaw,–í—ñ—à–æ—É,Ÿà€åÿ¥ÿßŸà,Â®ÅËï≠",55.76667,-3.91667,P,PPL,GB,,SCT,V8,,,30510,,138,Europe/London,12/06/2017
-2633771,Wisbech,Wisbech,"Uisbijch,Visbicas,Visbiƒças,Vizbich,Wisbech,wysbch,–í–∏–∑–±–∏—á,–£–∏—Å–±–∏–π—á,Ÿà€åÿ≥ÿ®⁄Ü",52.66622,0.15938,P,PPL,GB,,ENG,C3,12UD,12UD014,32489,,6,Europe/London,03/07/2018
-2633810,Winsford,Winsford,"wynsfwrd,Ÿà€åŸÜÿ≥ŸÅŸàÿ±ÿØ",53.19146,-2.52398,P,PPLA3,GB,,ENG,Z8,00EW163,,30259,,36,Europe/London,13/06/2017
+2633708,Wokingham,Wokingham,"Uokingam,Uokingkhem,oking-eom,wwkyngham,Уокингам,Уокингхем,ووکینگهام,오킹엄",51.4112,-0.83565,P,PPLA2,GB,,ENG,Q2,00MF015,,41143,,72,Europe/London,22/06/2016
+2633709,Woking,Woking,"Uoking,Uokinge,Vokingas,Woking,XWO,u~okingu,wo jin,wwdkyng,wwkng,Уокинг,Уокинге,وودکینگ,ووکنگ,ウォキング,沃金",51.31903,-0.55893,P,PPL,GB,,ENG,N7,43UM,,103932,,39,Europe/London,03/08/2010
+2633729,Witney,Witney,"Uitni,Witney,wytny,Уитни,ویتنی",51.7836,-1.4854,P,PPL,GB,,ENG,K2,38UF,38UF080,29103,,87,Europe/London,03/07/2018
+2633749,Witham,Witham,"wytham,ویتهام",51.80007,0.64038,P,PPL,GB,,ENG,E4,22UC,22UC063,25353,,25,Europe/London,03/07/2018
+2633765,Wishaw,Wishaw,"Camas Neachdain,Vishou,Wishae,Wishaw,wei xiao,wyshaw,Вішоу,ویشاو,威蕭",55.76667,-3.91667,P,PPL,GB,,SCT,V8,,,30510,,138,Europe/London,12/06/2017
+2633771,Wisbech,Wisbech,"Uisbijch,Visbicas,Visbičas,Vizbich,Wisbech,wysbch,Визбич,Уисбийч,ویسبچ",52.66622,0.15938,P,PPL,GB,,ENG,C3,12UD,12UD014,32489,,6,Europe/London,03/07/2018
+2633810,Winsford,Winsford,"wynsfwrd,وینسفورد",53.19146,-2.52398,P,PPLA3,GB,,ENG,Z8,00EW163,,30259,,36,Europe/London,13/06/2017
 `
 
 	type test struct {
@@ -333,7 +916,7 @@ func TestSearchWithLocation(t *testing.T) {
 				t.Fatalf("failed to make city searcher: %s", err)
 			}
 
-			results, err := cs.SearchWithLocation(context.Background(), tc.query, tc.lat, tc.lng)
+			results, err := cs.SearchWithLocation(context.Background(), tc.query, tc.lat, tc.lng, cities.SearchOptions{})
 			if err != nil {
 				t.Fatalf("failed to  search: %s", err)
 			}
@@ -343,3 +926,273 @@ func TestSearchWithLocation(t *testing.T) {
 		})
 	}
 }
+
+// syntheticCSV generates n rows of plausible looking city names, so that
+// benchmarks can run against a dataset the size of the full GeoNames dump
+func syntheticCSV(n int) string {
+	onsets := []string{"", "b", "br", "c", "ch", "d", "f", "g", "gl", "h", "k", "l", "m", "n", "p", "r", "s", "st", "t", "th", "v", "w", "z"}
+	vowels := []string{"a", "e", "i", "o", "u", "ai", "ou", "ea"}
+	codas := []string{"", "n", "m", "r", "l", "s", "t", "ck", "rd", "ng"}
+
+	rng := rand.New(rand.NewSource(1))
+	syllable := func() string {
+		return onsets[rng.Intn(len(onsets))] + vowels[rng.Intn(len(vowels))] + codas[rng.Intn(len(codas))]
+	}
+
+	var sb strings.Builder
+	sb.WriteString("geonameid,name,latitude,longitude,country code\n")
+	for i := 0; i < n; i++ {
+		var name strings.Builder
+		for j := 0; j < 2+rng.Intn(3); j++ {
+			name.WriteString(syllable())
+		}
+		fmt.Fprintf(&sb, "%d,%s,%f,%f,GB\n", i, strings.Title(name.String()), rng.Float64()*180-90, rng.Float64()*360-180)
+	}
+	return sb.String()
+}
+
+func BenchmarkNewCitySearcher(b *testing.B) {
+	csv := syntheticCSV(200000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := cities.NewCitySearcher(strings.NewReader(csv)); err != nil {
+			b.Fatalf("failed to make city searcher: %s", err)
+		}
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	cs, err := cities.NewCitySearcher(strings.NewReader(syntheticCSV(200000)))
+	if err != nil {
+		b.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	modes := map[string]cities.Mode{"fuzzy": cities.ModeFuzzy, "prefix": cities.ModePrefix}
+	for modeName, mode := range modes {
+		for _, query := range []string{"l", "lon", "stor", "glenmar", "chailsound"} {
+			q, opts := query, cities.SearchOptions{Limit: 10, Mode: mode}
+			b.Run(modeName+"/"+q, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := cs.Search(context.Background(), q, opts); err != nil {
+						b.Fatalf("failed to search: %s", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkSearchWithLocation(b *testing.B) {
+	cs, err := cities.NewCitySearcher(strings.NewReader(syntheticCSV(200000)))
+	if err != nil {
+		b.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	modes := map[string]cities.Mode{"fuzzy": cities.ModeFuzzy, "prefix": cities.ModePrefix}
+	for modeName, mode := range modes {
+		for _, query := range []string{"l", "lo", "lon", "stor", "glenmar"} {
+			q, opts := query, cities.SearchOptions{Limit: 10, Mode: mode}
+			b.Run(modeName+"/"+q, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := cs.SearchWithLocation(context.Background(), q, 51.5, -0.1, opts); err != nil {
+						b.Fatalf("failed to search: %s", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkSearchLimits(b *testing.B) {
+	cs, err := cities.NewCitySearcher(strings.NewReader(syntheticCSV(200000)))
+	if err != nil {
+		b.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	// 0 is no limit, so every result is ranked
+	for _, limit := range []int{0, 10, 100} {
+		opts := cities.SearchOptions{Limit: limit}
+		b.Run(fmt.Sprint(limit), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := cs.SearchWithLocation(context.Background(), "lon", 51.5, -0.1, opts); err != nil {
+					b.Fatalf("failed to search: %s", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchLargeDataset(t *testing.T) {
+	csv := syntheticCSV(50000)
+	cs, err := cities.NewCitySearcher(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	// pick a few names from the middle of the file; each should come back
+	// as the best match for itself, whatever the index did to get there
+	lines := strings.Split(csv, "\n")
+	for _, l := range lines[20000:20005] {
+		name := strings.Split(l, ",")[1]
+		results, err := cs.Search(context.Background(), name, cities.SearchOptions{Limit: 1})
+		if err != nil {
+			t.Fatalf("failed to search: %s", err)
+		}
+		if len(results) == 0 || results[0].Score != 1.0 {
+			t.Fatalf("expected an exact match for %s, got %v", name, results)
+		}
+	}
+}
+
+func TestSearchPaging(t *testing.T) {
+	csv := "geonameid,name,latitude,longitude\n1,Aa,0,0\n2,Aaa,0,0\n3,Aaaa,0,0\n4,Aaaaa,0,0\n"
+
+	type test struct {
+		name     string
+		opts     cities.SearchOptions
+		expected []string
+	}
+
+	cases := []test{
+		{
+			name:     "no limit returns everything",
+			opts:     cities.SearchOptions{},
+			expected: []string{"Aa", "Aaa", "Aaaa", "Aaaaa"},
+		},
+		{
+			name:     "limit returns the best results",
+			opts:     cities.SearchOptions{Limit: 2},
+			expected: []string{"Aa", "Aaa"},
+		},
+		{
+			name:     "offset skips the best results",
+			opts:     cities.SearchOptions{Limit: 2, Offset: 1},
+			expected: []string{"Aaa", "Aaaa"},
+		},
+		{
+			name:     "limit past the end returns what's left",
+			opts:     cities.SearchOptions{Limit: 10, Offset: 3},
+			expected: []string{"Aaaaa"},
+		},
+		{
+			name:     "offset past the end returns nothing",
+			opts:     cities.SearchOptions{Limit: 10, Offset: 10},
+			expected: []string{},
+		},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cs, err := cities.NewCitySearcher(strings.NewReader(csv))
+			if err != nil {
+				t.Fatalf("failed to make city searcher: %s", err)
+			}
+
+			check := func(results []cities.CityWithScore) {
+				got := make([]string, len(results))
+				for i, r := range results {
+					got[i] = r.Name
+				}
+				if !reflect.DeepEqual(tc.expected, got) {
+					t.Fatalf("expected %v, got %v", tc.expected, got)
+				}
+			}
+
+			results, err := cs.Search(context.Background(), "a", tc.opts)
+			if err != nil {
+				t.Fatalf("failed to search: %s", err)
+			}
+			check(results)
+
+			// all in the same place, so location shouldn't change the order
+			results, err = cs.SearchWithLocation(context.Background(), "a", 10, 10, tc.opts)
+			if err != nil {
+				t.Fatalf("failed to search: %s", err)
+			}
+			check(results)
+		})
+	}
+}
+
+func TestSearchPagesMatchFullRanking(t *testing.T) {
+	// plenty of results, with plenty of tied scores
+	cs, err := cities.NewCitySearcher(strings.NewReader(syntheticCSV(5000)))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	ids := func(results []cities.CityWithScore) []string {
+		ids := make([]string, len(results))
+		for i, r := range results {
+			ids[i] = r.GeoNameID
+		}
+		return ids
+	}
+
+	search := map[string]func(opts cities.SearchOptions) ([]cities.CityWithScore, error){
+		"without location": func(opts cities.SearchOptions) ([]cities.CityWithScore, error) {
+			return cs.Search(context.Background(), "ar", opts)
+		},
+		"with location": func(opts cities.SearchOptions) ([]cities.CityWithScore, error) {
+			return cs.SearchWithLocation(context.Background(), "ar", 10, 10, opts)
+		},
+	}
+
+	for name, search := range search {
+		for _, mode := range []cities.Mode{cities.ModeFuzzy, cities.ModePrefix} {
+			all, err := search(cities.SearchOptions{Mode: mode})
+			if err != nil {
+				t.Fatalf("failed to search: %s", err)
+			}
+			if len(all) < 100 {
+				t.Fatalf("expected plenty of results %s, got %d", name, len(all))
+			}
+
+			for offset := 0; offset < 90; offset += 7 {
+				results, err := search(cities.SearchOptions{Limit: 10, Offset: offset, Mode: mode})
+				if err != nil {
+					t.Fatalf("failed to search: %s", err)
+				}
+				if expected := ids(all[offset : offset+10]); !reflect.DeepEqual(expected, ids(results)) {
+					t.Fatalf("expected page at %d %s to be %v, got %v", offset, name, expected, ids(results))
+				}
+			}
+		}
+	}
+}
+
+func TestCity(t *testing.T) {
+	t.Parallel()
+
+	csv := `geonameid,name,latitude,longitude,country code,population
+2633709,Woking,51.31903,-0.55893,GB,103932
+2633708,Wokingham,51.4112,-0.83565,GB,41143
+2633708,Wokingham Again,51.4112,-0.83565,GB,41143
+`
+	cs, err := cities.NewCitySearcher(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	c, err := cs.City("2633709")
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	expected := cities.City{GeoNameID: "2633709", Name: "Woking", Lat: 51.31903, Lng: -0.55893, CountryCode: "GB", Population: 103932}
+	if !reflect.DeepEqual(c, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, c)
+	}
+
+	// the first city with an ID keeps it
+	if c, err := cs.City("2633708"); err != nil || c.Name != "Wokingham" {
+		t.Fatalf("expected Wokingham, got %+v (%v)", c, err)
+	}
+
+	for _, id := range []string{"", "1", "Woking"} {
+		if _, err := cs.City(id); !errors.Is(err, cities.ErrCityNotFound) {
+			t.Fatalf("expected %v for %q, got %v", cities.ErrCityNotFound, id, err)
+		}
+	}
+}
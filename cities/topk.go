@@ -0,0 +1,64 @@
+package cities
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// rank orders results best first and cuts them down to the page opts asks
+// for. Ties keep their order, so that they keep their text ranking and
+// pages don't shuffle. When there's a limit, only the best Offset+Limit
+// results are kept on a heap as they go by, rather than sorting them all,
+// since clients rarely want more than a handful.
+func rank(results []CityWithScore, opts SearchOptions) []CityWithScore {
+	k := opts.Offset + opts.Limit
+	if opts.Limit <= 0 || k >= len(results) {
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+		return page(results, opts)
+	}
+
+	// the heap holds positions in results rather than the results themselves,
+	// which are big enough that moving them around is most of the cost
+	h := &topKHeap{results: results, ids: make([]int, 0, k)}
+	for i := range results {
+		if len(h.ids) < k {
+			heap.Push(h, i)
+		} else if h.better(i, h.ids[0]) {
+			h.ids[0] = i
+			heap.Fix(h, 0)
+		}
+	}
+
+	sort.Slice(h.ids, func(i, j int) bool { return h.better(h.ids[i], h.ids[j]) })
+	best := make([]CityWithScore, len(h.ids))
+	for i, id := range h.ids {
+		best[i] = results[id]
+	}
+	return page(best, opts)
+}
+
+// topKHeap is a min heap of positions in results, worst at the top, so
+// that it is always to hand to be replaced by something better
+type topKHeap struct {
+	results []CityWithScore
+	ids     []int
+}
+
+// better is whether results[i] ranks above results[j]: a higher score,
+// or the same score and earlier
+func (h *topKHeap) better(i, j int) bool {
+	if h.results[i].Score != h.results[j].Score {
+		return h.results[i].Score > h.results[j].Score
+	}
+	return i < j
+}
+
+func (h *topKHeap) Len() int           { return len(h.ids) }
+func (h *topKHeap) Less(i, j int) bool { return h.better(h.ids[j], h.ids[i]) }
+func (h *topKHeap) Swap(i, j int)      { h.ids[i], h.ids[j] = h.ids[j], h.ids[i] }
+func (h *topKHeap) Push(x interface{}) { h.ids = append(h.ids, x.(int)) }
+func (h *topKHeap) Pop() interface{} {
+	x := h.ids[len(h.ids)-1]
+	h.ids = h.ids[:len(h.ids)-1]
+	return x
+}
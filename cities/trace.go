@@ -0,0 +1,47 @@
+package cities
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans searches start
+const tracerName = "github.com/oskanberg/citysearch/cities"
+
+// startSpan starts a span called name under the one in ctx. It uses that
+// span's TracerProvider, so searches are only traced when the caller's
+// request is, and nothing needs setting up globally.
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName).Start(ctx, name)
+}
+
+// startSearchSpan starts the span for a whole search, describing it. The
+// query itself is left out, as it can be from the access log; its length
+// says as much as is needed about how much work it was.
+func startSearchSpan(ctx context.Context, name, query string, area Area, opts SearchOptions) (context.Context, trace.Span) {
+	ctx, span := startSpan(ctx, name)
+	if span.IsRecording() {
+		span.SetAttributes(
+			attribute.Int("citysearch.query_length", len([]rune(query))),
+			attribute.String("citysearch.mode", opts.Mode.String()),
+			attribute.Int("citysearch.limit", opts.Limit),
+			attribute.Int("citysearch.offset", opts.Offset),
+			attribute.StringSlice("citysearch.countries", opts.Countries),
+			attribute.Bool("citysearch.within_area", area != nil),
+			attribute.Bool("citysearch.filtered", opts.Filter != nil),
+		)
+	}
+	return ctx, span
+}
+
+// endSpan ends span, marking it failed if err is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
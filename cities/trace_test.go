@@ -0,0 +1,92 @@
+package cities_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/oskanberg/citysearch/cities"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSearchTraced(t *testing.T) {
+	t.Parallel()
+
+	cs, err := cities.NewCitySearcher(strings.NewReader(syntheticCSV(2000)))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	// searches are only traced under a span of the caller's
+	if _, err := cs.Search(context.Background(), "ar", cities.SearchOptions{}); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Fatalf("expected no spans without a trace, got %d", len(spans))
+	}
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	result, err := cs.Search(ctx, "ar", cities.SearchOptions{Limit: 5, Mode: cities.ModePrefix})
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	parent.End()
+
+	spans := exporter.GetSpans()
+	names := make([]string, len(spans))
+	for i, s := range spans {
+		names[i] = s.Name
+	}
+	// in the order they ended
+	expected := []string{"candidates", "score", "rank", "CitySearcher.Search", "request"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected spans %v, got %v", expected, names)
+	}
+
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, a := range spans[3].Attributes {
+		attrs[a.Key] = a.Value
+	}
+	if attrs["citysearch.mode"].AsString() != "prefix" || attrs["citysearch.limit"].AsInt64() != 5 || attrs["citysearch.query_length"].AsInt64() != 2 {
+		t.Fatalf("expected the search to be described, got %v", spans[3].Attributes)
+	}
+	for _, a := range spans[2].Attributes {
+		if a.Key == "citysearch.results" && a.Value.AsInt64() != int64(len(result)) {
+			t.Fatalf("expected %d results, got %d", len(result), a.Value.AsInt64())
+		}
+	}
+}
+
+func TestSearchTracedCancelled(t *testing.T) {
+	t.Parallel()
+
+	cs, err := cities.NewCitySearcher(strings.NewReader(syntheticCSV(2000)))
+	if err != nil {
+		t.Fatalf("failed to make city searcher: %s", err)
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := cs.SearchWithLocation(ctx, "ar", 51.5, -0.1, cities.SearchOptions{}); err == nil {
+		t.Fatalf("expected the search to be cancelled")
+	}
+	parent.End()
+
+	for _, s := range exporter.GetSpans() {
+		if s.Name == "CitySearcher.SearchWithLocation" {
+			if s.Status.Code != codes.Error {
+				t.Fatalf("expected the search span to have failed, got %v", s.Status)
+			}
+			return
+		}
+	}
+	t.Fatalf("expected a CitySearcher.SearchWithLocation span")
+}
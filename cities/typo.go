@@ -0,0 +1,192 @@
+package cities
+
+import (
+	"context"
+	"sort"
+	"unicode/utf8"
+)
+
+// typoIndex finds names within a few typos of a query. It holds each
+// distinct name once, sorted, and walks them as if they were a trie: names
+// sharing a prefix share the rows of the edit distance table for it, and as
+// soon as a prefix is too far from the query to ever come back within range,
+// every name starting with it is skipped. That makes it a Levenshtein
+// automaton in all but name, run over the index.
+type typoIndex struct {
+	names []string
+	runes [][]rune
+	// ids are the positions in the index with each name
+	ids [][]int32
+}
+
+// newTypoIndex builds the index over names, which are positions in the index
+func newTypoIndex(names []string) *typoIndex {
+	byName := make(map[string][]int32)
+	for i, n := range names {
+		byName[n] = append(byName[n], int32(i))
+	}
+
+	t := &typoIndex{names: make([]string, 0, len(byName))}
+	for n := range byName {
+		t.names = append(t.names, n)
+	}
+	sort.Strings(t.names)
+
+	t.runes = make([][]rune, len(t.names))
+	t.ids = make([][]int32, len(t.names))
+	for i, n := range t.names {
+		t.runes[i] = []rune(n)
+		t.ids[i] = byName[n]
+	}
+	return t
+}
+
+// find returns every name within maxDistance of query, by optimal string
+// alignment distance: the number of insertions, deletions, substitutions
+// and transpositions of adjacent runes needed to turn one into the other
+func (t *typoIndex) find(ctx context.Context, query string, maxDistance int) ([]match, error) {
+	q := []rune(query)
+
+	// rows[d] is the row of the table for the first d runes of the current
+	// name; rows[0] is the distance from an empty name. Only rows up to
+	// valid are for the current name; past that they are for earlier ones.
+	rows := [][]int{make([]int, len(q)+1)}
+	mins := []int{0}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+	valid := 0
+
+	var matches []match
+	var prev []rune
+	for i, steps := 0, 0; i < len(t.names); steps++ {
+		if err := cancelled(ctx, steps); err != nil {
+			return nil, err
+		}
+		name := t.runes[i]
+		start := commonPrefix(prev, name)
+		if start > valid {
+			start = valid
+		}
+		prev = name
+
+		pruned := false
+		for d := start + 1; d <= len(name); d++ {
+			if len(rows) <= d {
+				rows = append(rows, make([]int, len(q)+1))
+				mins = append(mins, 0)
+			}
+			mins[d] = fillRow(rows, d, q, name)
+			valid = d
+
+			// a transposition can reach back two rows, so both must be out of range
+			if mins[d] > maxDistance && mins[d-1] > maxDistance-1 {
+				// every name starting with this one's first d runes is out of range
+				i = t.skip(i, name[:d])
+				pruned = true
+				break
+			}
+		}
+		if pruned {
+			continue
+		}
+
+		if d := rows[len(name)][len(q)]; d <= maxDistance {
+			for _, id := range t.ids[i] {
+				matches = append(matches, match{id, d})
+			}
+		}
+		i++
+	}
+	return matches, nil
+}
+
+// skip returns the position of the first name after i not starting with
+// prefix, which names[i] must start with. Runs of names sharing a prefix
+// are usually short, so it gallops forwards before searching back.
+func (t *typoIndex) skip(i int, prefix []rune) int {
+	hasPrefix := func(j int) bool {
+		r := t.runes[j]
+		return len(r) >= len(prefix) && commonPrefix(r[:len(prefix)], prefix) == len(prefix)
+	}
+
+	lo, step := i, 1
+	for lo+step < len(t.names) && hasPrefix(lo+step) {
+		lo += step
+		step *= 2
+	}
+	hi := lo + step
+	if hi > len(t.names) {
+		hi = len(t.names)
+	}
+	// names[lo] has the prefix, and names[hi] (if it exists) doesn't
+	return lo + 1 + sort.Search(hi-lo-1, func(j int) bool { return !hasPrefix(lo + 1 + j) })
+}
+
+// fillRow works out rows[d] of the optimal string alignment table between
+// q and name from the rows before it, returning its smallest value
+func fillRow(rows [][]int, d int, q, name []rune) int {
+	cur, prev := rows[d], rows[d-1]
+	cur[0] = d
+	min := d
+	for j := 1; j <= len(q); j++ {
+		cost := 1
+		if q[j-1] == name[d-1] {
+			cost = 0
+		}
+		cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		if d > 1 && j > 1 && q[j-1] == name[d-2] && q[j-2] == name[d-1] && rows[d-2][j-2]+1 < cur[j] {
+			cur[j] = rows[d-2][j-2] + 1
+		}
+		if cur[j] < min {
+			min = cur[j]
+		}
+	}
+	return min
+}
+
+// commonPrefix is how many runes a and b start with in common
+func commonPrefix(a, b []rune) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// maxTypos is how many edits a query of this many runes can have in it
+// and still match: short queries have too little to go on for more
+func maxTypos(runes int) int {
+	switch {
+	case runes < 3:
+		return 0
+	case runes < 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// findTypos returns every name within a few edits (insertions, deletions,
+// substitutions or transpositions) of query, which must be normalised.
+// Unlike find, the whole name is compared, so it is for names that have
+// been typed in full but misspelt. Matches are ordered best first, ties
+// broken by position in the index.
+func (idx *nameIndex) findTypos(ctx context.Context, query string) ([]match, error) {
+	matches, err := idx.typos.find(ctx, query, maxTypos(utf8.RuneCountInString(query)))
+	if err != nil {
+		return nil, err
+	}
+	sortMatches(matches)
+	return matches, nil
+}
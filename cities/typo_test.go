@@ -0,0 +1,95 @@
+package cities_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/oskanberg/citysearch/cities"
+)
+
+func TestTypoMode(t *testing.T) {
+	csv := `geonameid,name,latitude,longitude
+2643743,London,51.50853,-0.12574
+2643123,Manchester,53.48095,-2.23743
+2655603,Birmingham,52.48142,-1.89983
+2654675,Bristol,51.45523,-2.59665
+2644210,Liverpool,53.41058,-2.97794
+2636432,Swindon,51.55797,-1.78116
+`
+
+	type test struct {
+		name     string
+		query    string
+		mode     cities.Mode
+		expected string
+		score    float64
+	}
+
+	cases := []test{
+		{name: "transposed letters", query: "Lodnon", mode: cities.ModeTypo, expected: "London", score: 0.5},
+		{name: "missing letter", query: "Mancester", mode: cities.ModeTypo, expected: "Manchester", score: 0.5},
+		{name: "extra letter", query: "Bristoll", mode: cities.ModeTypo, expected: "Bristol", score: 0.5},
+		{name: "wrong letter", query: "Liverpoll", mode: cities.ModeTypo, expected: "Liverpool", score: 0.5},
+		{name: "two typos in a long name", query: "Birminghma", mode: cities.ModeTypo, expected: "Birmingham", score: 0.5},
+		{name: "transposition and missing letter", query: "Brimngham", mode: cities.ModeTypo, expected: "Birmingham", score: 1.0 / 3},
+		{name: "exact match still matches", query: "London", mode: cities.ModeTypo, expected: "London", score: 1},
+		{name: "fuzzy matches still match", query: "Swin", mode: cities.ModeTypo, expected: "Swindon", score: 1.0 / 4},
+		{name: "too many typos for a short name", query: "Lodnn", mode: cities.ModeTypo},
+		{name: "fuzzy mode doesn't allow typos", query: "Lodnon", mode: cities.ModeFuzzy},
+	}
+
+	for _, tt := range cases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cs, err := cities.NewCitySearcher(strings.NewReader(csv))
+			if err != nil {
+				t.Fatalf("failed to make city searcher: %s", err)
+			}
+
+			results, err := cs.Search(context.Background(), tc.query, cities.SearchOptions{Mode: tc.mode})
+			if err != nil {
+				t.Fatalf("failed to search: %s", err)
+			}
+
+			if tc.expected == "" {
+				if len(results) != 0 {
+					t.Fatalf("expected no results, got %v", results)
+				}
+				return
+			}
+			if len(results) == 0 || results[0].Name != tc.expected {
+				t.Fatalf("expected the first result to be %s, got %v", tc.expected, results)
+			}
+			if results[0].Score != tc.score {
+				t.Fatalf("expected score %f, got %f", tc.score, results[0].Score)
+			}
+		})
+	}
+}
+
+func BenchmarkTypoSearch(b *testing.B) {
+	cs, err := cities.NewCitySearcher(strings.NewReader(syntheticCSV(200000)))
+	if err != nil {
+		b.Fatalf("failed to make city searcher: %s", err)
+	}
+
+	opts := cities.SearchOptions{Limit: 10, Mode: cities.ModeTypo}
+	// the first typo search builds the tree, which shouldn't count
+	if _, err := cs.Search(context.Background(), "warmup", opts); err != nil {
+		b.Fatalf("failed to search: %s", err)
+	}
+	b.ResetTimer()
+
+	for _, query := range []string{"lnod", "stroa", "glenmra", "chialsound"} {
+		q := query
+		b.Run(q, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := cs.Search(context.Background(), q, opts); err != nil {
+					b.Fatalf("failed to search: %s", err)
+				}
+			}
+		})
+	}
+}
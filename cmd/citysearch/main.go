@@ -1,40 +1,239 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/oskanberg/citysearch/api"
 	"github.com/oskanberg/citysearch/cities"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// defaultPopulationWeight is how much population counts unless the
+// --population-weight flag says otherwise: half as much as the text, enough
+// for "Lon" to suggest London ahead of a village called Lone. Searches made
+// with cities.DefaultScorer leave population out.
+const defaultPopulationWeight = 0.5
+
 func main() {
 	log.SetLevel(log.InfoLevel)
 
 	fLoc := flag.String("cities", "", "location of the cities csv file")
 	fPort := flag.String("port", ":80", "port to serve on")
+	fTextWeight := flag.Float64("text-weight", cities.DefaultWeights.Text, "weight of the text match in result scores")
+	fDistanceWeight := flag.Float64("distance-weight", cities.DefaultWeights.Distance, "weight of the distance from the caller's location in result scores")
+	fPopulationWeight := flag.Float64("population-weight", defaultPopulationWeight, "weight of the city's population in result scores")
+	fScorer := flag.String("scorer", "linear", "how distance is scored: linear (relative to the nearest match) or log-decay")
+	fDecayKm := flag.Float64("decay-km", 10, "distance in km at which the log-decay scorer starts falling off")
+	fFilter := flag.String("filter", "", "filter expression limiting which cities are loaded, e.g. \"population > 5000\"")
+	fRequestTimeout := flag.Duration("request-timeout", 5*time.Second, "how long a request can take before it is abandoned with a 503")
+	fShutdownTimeout := flag.Duration("shutdown-timeout", 15*time.Second, "how long to wait for in-flight requests to finish on SIGTERM")
+	fLogSample := flag.Float64("access-log-sample", 1, "fraction of requests to write to the access log, from 0 to 1; server errors are always logged")
+	fLogRedact := flag.Bool("access-log-redact", false, "leave what was searched for out of the access log, logging only its length")
+	fOTLP := flag.String("otlp-endpoint", "", "OTLP/HTTP endpoint to send traces to, e.g. http://localhost:4318; unset to not trace")
+	fWatch := flag.Duration("watch-interval", 10*time.Second, "how often to check the cities database for changes to reload; 0 to only reload on SIGHUP")
 	flag.Parse()
 
 	if fLoc == nil || *fLoc == "" {
 		log.Fatalf("flag --cities must be set to the location of the cities database")
 	}
 
-	f, err := os.Open(*fLoc)
-	if err != nil {
-		log.Fatalf("cities database could not be opened: %s", err)
+	weights := cities.Weights{
+		Text:       *fTextWeight,
+		Distance:   *fDistanceWeight,
+		Population: *fPopulationWeight,
+	}
+	var scorer cities.Scorer
+	switch *fScorer {
+	case "linear":
+		scorer = cities.LinearScorer{Weights: weights}
+	case "log-decay":
+		scorer = cities.LogDecayScorer{Weights: weights, ScaleKm: *fDecayKm}
+	default:
+		log.Fatalf("flag --scorer must be linear or log-decay, not %s", *fScorer)
+	}
+
+	var filters []cities.FilterFunc
+	if *fFilter != "" {
+		filter, err := cities.ParseFilter(*fFilter)
+		if err != nil {
+			log.Fatalf("flag --filter could not be parsed: %s", err)
+		}
+		filters = append(filters, filter)
 	}
 
-	searcher, err := cities.NewCitySearcher(f, cities.OnlyGB)
+	tp, err := newTracerProvider(*fOTLP)
 	if err != nil {
-		log.Fatalf("failed to create city searcher: %s", err)
+		log.Fatalf("flag --otlp-endpoint could not be used: %s", err)
+	}
+
+	// big databases take a while to load, so this starts out empty and
+	// is loaded once the server is up, saying it isn't ready until then
+	searcher := cities.NewPendingReloader(cities.FileLoader(*fLoc, scorer, filters...))
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	metrics := api.NewMetrics(reg, searcher)
+
+	// a failed reload leaves the previous data serving, so just say so
+	logReload := func(err error) {
+		metrics.ObserveReload(err)
+		if err != nil {
+			log.Errorf("keeping previous cities database: %s", err)
+			return
+		}
+		log.Info("cities database reloaded")
 	}
+	// stopped on shutdown, along with anything else running in the background
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	// if the first load fails, stay up but unready: fixing the file will
+	// be noticed by the watcher, or a SIGHUP will try again
+	go func() {
+		err := searcher.Reload()
+		metrics.ObserveReload(err)
+		if err != nil {
+			log.Errorf("failed to load cities database: %s", err)
+			return
+		}
+		log.Infof("cities database loaded: %d cities", searcher.Stats().Cities)
+	}()
+	if *fWatch > 0 {
+		searcher.Watch(ctx, *fLoc, *fWatch, logReload)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Info("SIGHUP received, reloading cities database")
+			logReload(searcher.Reload())
+		}
+	}()
+
+	suggestions := metrics.Instrument("suggestions", api.Trace("suggestions", api.NewCitySearchHandler(searcher), tp))
+	nearest := metrics.Instrument("nearest", api.Trace("nearest", api.NewNearestHandler(searcher), tp))
+	city := metrics.Instrument("city", api.Trace("city", api.NewCityHandler(searcher), tp))
+	ready := api.NewReadinessHandler(searcher)
+	live := api.NewLivenessHandler()
+
+	router := api.NewRouter()
+	router.Handle(http.MethodGet, "/v1/suggestions", suggestions)
+	router.Handle(http.MethodGet, "/v1/nearest", nearest)
+	router.Handle(http.MethodGet, "/v1/cities/{id}", city)
+	// from before the API was versioned; kept for the clients still using them
+	router.Handle(http.MethodGet, "/suggestions", api.Deprecated(suggestions, "/v1/suggestions"))
+	router.Handle(http.MethodGet, "/nearest", api.Deprecated(nearest, "/v1/nearest"))
+	// operational endpoints, so not part of the versioned API
+	router.Handle(http.MethodGet, "/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		router.Handle(method, "/healthz", live)
+		router.Handle(method, "/readyz", ready)
+	}
+
+	srv := &http.Server{
+		Addr: *fPort,
+		Handler: api.AccessLog(api.WithTimeout(router, *fRequestTimeout), log.StandardLogger(), api.AccessLogOptions{
+			SampleRate:  *fLogSample,
+			RedactQuery: *fLogRedact,
+		}),
+		// requests are all small GETs, so anything slow to send one is up to no good
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		// leave time to write the response of a request that takes as long as it can
+		WriteTimeout: *fRequestTimeout + 5*time.Second,
+		IdleTimeout:  2 * time.Minute,
+	}
+
+	// on SIGTERM (or ^C), stop taking new requests and let the ones
+	// in flight finish before exiting
+	drained := make(chan struct{})
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-term
+		log.Infof("%s received, shutting down", sig)
+		stop()
 
-	// single endpoint, so don't feel the need to do any fancy muxing
-	http.HandleFunc("/suggestions", api.NewCitySearchHandler(searcher))
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), *fShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("requests still in flight at shutdown: %s", err)
+		}
+		// send off the last requests' spans
+		if err := tp.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("failed to flush traces at shutdown: %s", err)
+		}
+		close(drained)
+	}()
 
 	log.Info("Service starting on port ", *fPort)
-	log.Fatal(http.ListenAndServe(*fPort, nil))
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-drained
+	log.Info("Service stopped")
+}
+
+// tracerProvider is a TracerProvider that can be flushed on shutdown
+type tracerProvider interface {
+	trace.TracerProvider
+	Shutdown(ctx context.Context) error
+}
+
+// noopTracerProvider traces nothing, for when no endpoint is set
+type noopTracerProvider struct {
+	trace.TracerProvider
+}
+
+func (noopTracerProvider) Shutdown(context.Context) error { return nil }
+
+// newTracerProvider returns a TracerProvider sending spans in batches to
+// the OTLP/HTTP collector at endpoint, or one tracing nothing if it is empty
+func newTracerProvider(endpoint string) (tracerProvider, error) {
+	if endpoint == "" {
+		return noopTracerProvider{trace.NewNoopTracerProvider()}, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	switch u.Scheme {
+	case "http":
+		opts = append(opts, otlptracehttp.WithInsecure())
+	case "https":
+	default:
+		return nil, fmt.Errorf("endpoint must be an http or https URL, not %q", endpoint)
+	}
+	if u.Path != "" && u.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+
+	// the exporter connects lazily, so a collector that's down doesn't stop
+	// the service starting; spans are dropped until it's back
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("citysearch"))),
+	), nil
 }
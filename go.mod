@@ -4,7 +4,13 @@ go 1.16
 
 require (
 	github.com/jszwec/csvutil v1.5.0
-	github.com/lithammer/fuzzysearch v1.1.2
+	github.com/prometheus/client_golang v1.11.1
+	github.com/prometheus/client_model v0.2.0
 	github.com/sirupsen/logrus v1.8.1
 	github.com/umahmood/haversine v0.0.0-20151105152445-808ab04add26
+	go.opentelemetry.io/otel v1.7.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.7.0
+	go.opentelemetry.io/otel/sdk v1.7.0
+	go.opentelemetry.io/otel/trace v1.7.0
+	golang.org/x/text v0.3.8
 )